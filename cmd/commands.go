@@ -18,12 +18,25 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"kube-sidecar/config"
 	"kube-sidecar/pkg/clientset/kubernetes"
 	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/health"
+	"kube-sidecar/pkg/metrics"
+	"kube-sidecar/pkg/model/event"
 
 	ot "kube-sidecar/utils/opentelemetry"
 	"kube-sidecar/utils/tools"
+	"log"
+)
+
+// start命令参数,设置后覆盖配置文件中的controller.dryRun、controller.pprof与controller.logLevelEndpoint
+var (
+	startDryRun   bool
+	startPprof    bool
+	startLogLevel bool
 )
 
 // StartKubeSidecar 启动kube-sideacar服务
@@ -35,8 +48,6 @@ var StartKubeSidecar = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		var (
 			param       string
-			tracerName  string = "kube-sidecar"
-			spanName    string = "sidecar"
 			service     string = "kube-sidecar"
 			environment string = "qkp"
 			id          int64  = tools.RandomInt64()
@@ -47,21 +58,51 @@ var StartKubeSidecar = &cobra.Command{
 		default:
 			param = "start"
 		}
+		cfg, err := config.LoadConfigFromFile()
+		if err != nil {
+			log.Fatalln("加载配置文件失败,错误信息" + err.Error())
+		}
+		if cmd.Flags().Changed("dry-run") {
+			cfg.Controller.DryRun = startDryRun
+		}
+		if cmd.Flags().Changed("enable-pprof") {
+			cfg.Controller.Pprof = startPprof
+		}
+		if cmd.Flags().Changed("enable-loglevel-endpoint") {
+			cfg.Controller.LogLevelEndpoint = startLogLevel
+		}
+		// 初始化全局logger
+		cfg.LoggingConfig.Logger()
+		// 配置无效时直接退出,避免使用无效配置注入
+		if err = utilerrors.NewAggregate(cfg.Validate()); err != nil {
+			logging.Logger.Fatal("配置校验失败", zap.Error(err))
+		}
 		if param != "start" {
 			logging.Logger.Error("输入参数错误")
 		}
 		// 打印终端提示
 		logging.Logger.Info("成功启动kube-sidecar监听服务")
 		tools.TerminalColor()
-		cfg, _ := config.LoadConfigFromFile()
 		// 注册全局tracer
 		options := kubernetes.NewKubernetesOptions()
 		client, _ := kubernetes.NewKubernetesClient(options)
-		ot.NewOpenTelemetry(client, *cfg.FluentBitConfig, *cfg.Sidecar, *cfg.JaegerConfig, *cfg.WhiteList).TracerProvider(service, environment)
+		// 初始化全局事件记录器
+		defer event.Start(client)()
+		// 启动控制器指标服务
+		defer metrics.Serve(cfg.Controller.MetricsAddress)()
+		// 启动健康检查与调试服务
+		defer health.Serve(cfg.Controller.HealthAddress, cfg, cfg.Controller.Pprof, cfg.Controller.LogLevelEndpoint)()
+		if cfg.Controller.DryRun {
+			logging.Logger.Info("控制器运行在dry-run模式,所有修改只记录日志与事件,不实际写入集群")
+		}
+		ot.NewOpenTelemetry(client, *cfg.FluentBitConfig, *cfg.Sidecar, *cfg.JaegerConfig, *cfg.Tracing, *cfg.WhiteList, *cfg.Controller, *cfg.Monitoring).RegisterGlobalTracerProvider(service, environment, id)
 	},
 }
 
 // 注册到rootCmd
 func init() {
+	StartKubeSidecar.Flags().BoolVar(&startPprof, "enable-pprof", false, "Serve /debug/pprof on the health address")
+	StartKubeSidecar.Flags().BoolVar(&startLogLevel, "enable-loglevel-endpoint", false, "Serve /debug/loglevel on the health address to change the log level at runtime")
+	StartKubeSidecar.Flags().BoolVar(&startDryRun, "dry-run", false, "Observe only: use server-side dry-run for all writes and report intended changes as logs and Events")
 	rootCmd.AddCommand(StartKubeSidecar)
 }
@@ -0,0 +1,204 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"kube-sidecar/config"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/manifest"
+	"kube-sidecar/pkg/model/container"
+	"kube-sidecar/pkg/model/deploy"
+	"sigs.k8s.io/yaml"
+)
+
+// 定义diff命令参数
+var diffNamespace string
+
+// DiffKubeSidecar 按当前配置计算开启注入的工作负载的期望状态,输出与集群中pod模版及secret的差异
+var DiffKubeSidecar = &cobra.Command{
+	Use:           "diff",
+	Example:       "kube-sidecar diff\nkube-sidecar diff -n production",
+	Short:         "Show the pod template and Secret changes the current config would apply to opted-in workloads",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfigFromFile()
+		if err != nil {
+			return err
+		}
+		client, err := kubernetes.NewKubernetesClient(kubeOptions)
+		if err != nil {
+			return err
+		}
+		cfg.Sidecar.NativeSidecar = container.ResolveNative(cfg.Sidecar.NativeSidecar, client)
+		restart, secrets, err := writeDiffs(cmd.Context(), cmd.OutOrStdout(), cfg, client, diffNamespace)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%d个工作负载将重启,%d个secret将更新\n", restart, secrets)
+		return nil
+	},
+}
+
+// writeDiffs 输出namespace下开启注入的工作负载的差异,返回将重启的工作负载数量与将更新的secret数量
+func writeDiffs(ctx context.Context, w io.Writer, cfg *config.Config, client kubernetes.Client, namespace string) (restart, secrets int, err error) {
+	objects, err := listWorkloads(ctx, client, namespace)
+	if err != nil {
+		return 0, 0, err
+	}
+	d := deploy.NewDeploy(kubernetes.NewNullClient(), *cfg.FluentBitConfig, *cfg.Sidecar, *cfg.Controller)
+	for _, obj := range objects {
+		meta, spec, _ := manifest.PodTemplate(obj)
+		switch deploy.ExcludedReason(*meta, *spec, cfg.Sidecar.Name, *cfg.WhiteList) {
+		case "", deploy.ReasonAlreadyInjected:
+		default:
+			continue
+		}
+		id := meta.Namespace + "/" + obj.GetObjectKind().GroupVersionKind().Kind + "/" + meta.Name
+		desired, err := desiredState(d, meta, spec, cfg.Sidecar.Name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", id, err)
+		}
+		// 通过服务端dry-run获取apiserver填充默认值后的pod模版,避免默认字段造成误报
+		live := podTemplate(obj)
+		updated, err := dryRunUpdate(ctx, client, obj, desired)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", id, err)
+		}
+		if changed, err := writeDiff(w, id+" pod template", live, podTemplate(updated)); err != nil {
+			return 0, 0, err
+		} else if changed {
+			restart++
+		}
+		var liveData map[string][]byte
+		secretName := desired.Secret.Name
+		liveSecret, err := client.Kubernetes().CoreV1().Secrets(meta.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+		case err != nil:
+			return 0, 0, err
+		default:
+			liveData = liveSecret.Data
+		}
+		if changed, err := writeDiff(w, meta.Namespace+"/Secret/"+secretName, secretData(liveData), secretData(desired.Secret.Data)); err != nil {
+			return 0, 0, err
+		} else if changed {
+			secrets++
+		}
+	}
+	return restart, secrets, nil
+}
+
+// podTemplate 获取工作负载的pod模版
+func podTemplate(obj runtime.Object) *corev1.PodTemplateSpec {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &o.Spec.Template
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template
+	default:
+		return nil
+	}
+}
+
+// dryRunUpdate 以服务端dry-run方式提交期望状态,返回apiserver填充默认值后的对象,不修改集群
+func dryRunUpdate(ctx context.Context, client kubernetes.Client, obj runtime.Object, desired *desiredWorkload) (runtime.Object, error) {
+	obj = obj.DeepCopyObject()
+	meta, spec, _ := manifest.PodTemplate(obj)
+	meta.Annotations = desired.Meta.Annotations
+	*spec = *desired.Spec
+	options := metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}}
+	apps := client.Kubernetes().AppsV1()
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return apps.Deployments(o.Namespace).Update(ctx, o, options)
+	case *appsv1.StatefulSet:
+		return apps.StatefulSets(o.Namespace).Update(ctx, o, options)
+	case *appsv1.DaemonSet:
+		return apps.DaemonSets(o.Namespace).Update(ctx, o, options)
+	default:
+		return nil, fmt.Errorf("不支持的工作负载类型 %T", obj)
+	}
+}
+
+// secretData 将secret数据转换为字符串便于对比,secret不存在时返回nil
+func secretData(data map[string][]byte) interface{} {
+	if data == nil {
+		return nil
+	}
+	out := make(map[string]string, len(data))
+	for k, v := range data {
+		out[k] = string(v)
+	}
+	return out
+}
+
+// writeDiff 以unified diff格式输出live与desired的差异,返回是否存在差异
+func writeDiff(w io.Writer, name string, live, desired interface{}) (bool, error) {
+	if equality.Semantic.DeepEqual(live, desired) {
+		return false, nil
+	}
+	a, err := marshalDiff(live)
+	if err != nil {
+		return false, err
+	}
+	b, err := marshalDiff(desired)
+	if err != nil {
+		return false, err
+	}
+	text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: "live/" + name,
+		ToFile:   "desired/" + name,
+		Context:  3,
+	})
+	if err != nil || text == "" {
+		return false, err
+	}
+	fmt.Fprint(w, text)
+	return true, nil
+}
+
+// marshalDiff 序列化为YAML,对象不存在时为空
+func marshalDiff(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	data, err := yaml.Marshal(v)
+	return string(data), err
+}
+
+// 注册到rootCmd
+func init() {
+	DiffKubeSidecar.Flags().StringVarP(&diffNamespace, "namespace", "n", metav1.NamespaceAll, "Only diff workloads in this namespace, all namespaces if empty")
+	kubeOptions.AddFlags(DiffKubeSidecar.Flags(), kubeOptions)
+	rootCmd.AddCommand(DiffKubeSidecar)
+}
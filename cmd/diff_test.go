@@ -0,0 +1,110 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"kube-sidecar/config"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/model/deploy"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiffs(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	newDeployment := func(namespace string, annotated bool) *appsv1.Deployment {
+		dp := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace, Annotations: map[string]string{}},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "nginx"}},
+			}}},
+		}
+		if annotated {
+			dp.Annotations[deploy.AnnotationSidecar] = "true"
+			dp.Annotations["deployment.kubernetes.io/sidecar.backend"] = "elasticsearch"
+			dp.Annotations["deployment.kubernetes.io/sidecar.outputEsHost"] = "es"
+		}
+		return dp
+	}
+	// injected 按image注入sidecar后的工作负载与secret
+	injected := func(image string) []runtime.Object {
+		cfg := config.New()
+		cfg.Sidecar.Image = image
+		dp := newDeployment("shop", true)
+		d := deploy.NewDeploy(kubernetes.NewNullClient(), *cfg.FluentBitConfig, *cfg.Sidecar, *cfg.Controller)
+		newSecret, _, err := d.Inject(&dp.ObjectMeta, &dp.Spec.Template.Spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []runtime.Object{dp, newSecret}
+	}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		restart int
+		secrets int
+		// want 输出中应包含的diff标题
+		want []string
+	}{
+		{name: "not annotated", objects: []runtime.Object{newDeployment("shop", false)}},
+		{name: "namespace white list", objects: []runtime.Object{newDeployment("kube-system", true)}},
+		{
+			name:    "pending injection",
+			objects: []runtime.Object{newDeployment("shop", true)},
+			restart: 1, secrets: 1,
+			want: []string{"+++ desired/shop/Deployment/web pod template", "+++ desired/shop/Secret/web-sidecar"},
+		},
+		{name: "up to date", objects: injected(config.New().Sidecar.Image)},
+		{
+			name:    "sidecar image changed",
+			objects: injected("fluent/fluent-bit:1.9.0"),
+			restart: 1,
+			want:    []string{"-    image: fluent/fluent-bit:1.9.0", "+    image: " + config.New().Sidecar.Image},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.WhiteList.Namespaces = []string{"kube-system"}
+			client := kubernetes.NewFakeClientSets(fake.NewSimpleClientset(tt.objects...), nil, nil, "", nil)
+			var out bytes.Buffer
+			restart, secrets, err := writeDiffs(context.Background(), &out, cfg, client, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restart != tt.restart || secrets != tt.secrets {
+				t.Errorf("restart = %d, secrets = %d, want %d, %d\n%s", restart, secrets, tt.restart, tt.secrets, out.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output missing %q:\n%s", want, out.String())
+				}
+			}
+			if len(tt.want) == 0 && out.Len() != 0 {
+				t.Errorf("unexpected diff:\n%s", out.String())
+			}
+		})
+	}
+}
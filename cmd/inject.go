@@ -0,0 +1,123 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"kube-sidecar/config"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/manifest"
+	"kube-sidecar/pkg/model/deploy"
+	"os"
+	"strings"
+)
+
+// 定义inject命令参数
+var (
+	injectFilename  string
+	injectNamespace string
+)
+
+// InjectKubeSidecar 离线为清单中开启注入的工作负载注入sidecar容器、卷与fluentBit secret
+var InjectKubeSidecar = &cobra.Command{
+	Use:           "inject",
+	Example:       "kube-sidecar inject -f manifests.yaml > injected.yaml\nkustomize build . | kube-sidecar inject | kubectl apply -f -",
+	Short:         "Inject the sidecar into opted-in workloads of a multi-document manifest offline",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfigFromFile()
+		if err != nil {
+			return err
+		}
+		data, err := readInput(cmd, injectFilename)
+		if err != nil {
+			return err
+		}
+		d := deploy.NewDeploy(kubernetes.NewNullClient(), *cfg.FluentBitConfig, *cfg.Sidecar, *cfg.Controller)
+		var docs []string
+		injected := 0
+		for _, doc := range manifest.Split(data) {
+			obj, err := manifest.Decode([]byte(doc))
+			if err != nil {
+				// 无法识别的对象(如CRD)原样输出
+				docs = append(docs, doc)
+				continue
+			}
+			meta, spec, ok := manifest.PodTemplate(obj)
+			if !ok {
+				docs = append(docs, doc)
+				continue
+			}
+			// 清单未设置namespace时使用--namespace生成kubernetes元数据
+			target := *meta
+			if target.Namespace == "" {
+				target.Namespace = injectNamespace
+			}
+			if deploy.ExcludedReason(target, *spec, cfg.Sidecar.Name, *cfg.WhiteList) != "" {
+				docs = append(docs, doc)
+				continue
+			}
+			newSecret, warnings, err := d.Inject(&target, spec)
+			for _, warning := range warnings {
+				fmt.Fprintf(os.Stderr, "%s/%s: %s\n", target.Namespace, meta.Name, warning)
+			}
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", target.Namespace, meta.Name, err)
+			}
+			// 在原始文档上写入注入内容,不重新序列化工作负载,保留当前client-go版本不认识的字段
+			out, err := manifest.Inject(doc, &target, spec)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", target.Namespace, meta.Name, err)
+			}
+			docs = append(docs, out)
+			// secret与工作负载保持相同的namespace配置
+			newSecret.Namespace = meta.Namespace
+			secretOut, err := manifest.MarshalObject(newSecret)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, string(secretOut))
+			injected++
+		}
+		writeDocs(cmd.OutOrStdout(), docs)
+		fmt.Fprintf(os.Stderr, "已为%d个工作负载注入sidecar\n", injected)
+		return nil
+	},
+}
+
+// writeDocs 以YAML多文档格式输出
+func writeDocs(w io.Writer, docs []string) {
+	for i, doc := range docs {
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		fmt.Fprint(w, doc)
+		if !strings.HasSuffix(doc, "\n") {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// 注册到rootCmd
+func init() {
+	InjectKubeSidecar.Flags().StringVarP(&injectFilename, "filename", "f", "-", "Manifest file to inject, - for stdin")
+	InjectKubeSidecar.Flags().StringVarP(&injectNamespace, "namespace", "n", "default", "Namespace used for record metadata when the manifest does not set one")
+	rootCmd.AddCommand(InjectKubeSidecar)
+}
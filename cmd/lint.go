@@ -0,0 +1,75 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"kube-sidecar/pkg/validation"
+	"os"
+)
+
+// lintStrict 将warning级别问题视为错误
+var lintStrict bool
+
+// LintKubeSidecar 离线校验渲染后的fluentBit配置文件
+var LintKubeSidecar = &cobra.Command{
+	Use:     "lint [file...]",
+	Example: "kube-sidecar lint fluent-bit.conf\nkubectl get secret app-sidecar -o jsonpath='{.data.fluent-bit\\.conf}' | base64 -d | kube-sidecar lint -",
+	Short:   "Validate rendered fluent-bit configs offline",
+	// 校验失败时不打印帮助信息
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			args = []string{"-"}
+		}
+		failed := 0
+		for _, name := range args {
+			data, err := readInput(cmd, name)
+			if err != nil {
+				return err
+			}
+			problems := validation.Validate(data)
+			for _, p := range problems {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s:%s\n", name, p)
+			}
+			if problems.HasErrors() || (lintStrict && len(problems) > 0) {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d个配置文件校验失败", failed)
+		}
+		return nil
+	},
+}
+
+// readInput 读取文件内容,文件名称为-时读取标准输入
+func readInput(cmd *cobra.Command, name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(cmd.InOrStdin())
+	}
+	return os.ReadFile(name)
+}
+
+// 注册到rootCmd
+func init() {
+	LintKubeSidecar.Flags().BoolVar(&lintStrict, "strict", false, "Treat warnings as errors")
+	rootCmd.AddCommand(LintKubeSidecar)
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kube-sidecar/config"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/manifest"
+	"kube-sidecar/pkg/model/container"
+	"kube-sidecar/pkg/model/deploy"
+)
+
+// 定义render命令参数
+var (
+	renderFilename string
+	renderOutput   string
+)
+
+// renderResult 定义render命令输出结构体
+type renderResult struct {
+	Kind      string              `json:"kind"`
+	Name      string              `json:"name"`
+	Namespace string              `json:"namespace"`
+	Container corev1.Container    `json:"container"`
+	Volumes   []corev1.Volume     `json:"volumes"`
+	Secret    *corev1.Secret      `json:"secret"`
+	Warnings  []string            `json:"warnings,omitempty"`
+	Mounts    map[string][]string `json:"appVolumeMounts,omitempty"`
+	// NativeSidecar sidecar以restartPolicy Always的初始化容器注入
+	NativeSidecar bool `json:"nativeSidecar,omitempty"`
+}
+
+// RenderKubeSidecar 离线预览工作负载注入的sidecar容器、卷与fluentBit secret
+var RenderKubeSidecar = &cobra.Command{
+	Use:           "render",
+	Example:       "kube-sidecar render -f deployment.yaml\nkubectl get deploy app -o yaml | kube-sidecar render -o json",
+	Short:         "Preview the sidecar injected into a Deployment or StatefulSet manifest",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfigFromFile()
+		if err != nil {
+			return err
+		}
+		data, err := readInput(cmd, renderFilename)
+		if err != nil {
+			return err
+		}
+		results, err := renderManifests(cfg, data)
+		if err != nil {
+			return err
+		}
+		return writeRendered(cmd.OutOrStdout(), results, renderOutput)
+	},
+}
+
+// renderList 定义json格式下多个工作负载的输出结构体,与kubectl的List一致
+type renderList struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Items      []renderResult `json:"items"`
+}
+
+// renderManifests 按当前配置渲染清单中每个工作负载注入的sidecar,不调用kubernetes接口
+func renderManifests(cfg *config.Config, data []byte) ([]renderResult, error) {
+	d := deploy.NewDeploy(kubernetes.NewNullClient(), *cfg.FluentBitConfig, *cfg.Sidecar, *cfg.Controller)
+	var results []renderResult
+	for _, doc := range manifest.Split(data) {
+		obj, err := manifest.Decode([]byte(doc))
+		if err != nil {
+			return nil, err
+		}
+		meta, spec, ok := manifest.PodTemplate(obj)
+		if !ok {
+			continue
+		}
+		if meta.Namespace == "" {
+			meta.Namespace = "default"
+		}
+		warnings := injectWarnings(cfg, meta, spec)
+		before := spec.DeepCopy()
+		newSecret, annotationWarnings, err := d.Inject(meta, spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", meta.Namespace, meta.Name, err)
+		}
+		results = append(results, renderResult{
+			Kind:      obj.GetObjectKind().GroupVersionKind().Kind,
+			Name:      meta.Name,
+			Namespace: meta.Namespace,
+			Container: *findContainer(spec, cfg.Sidecar.Name),
+			Volumes:   spec.Volumes[len(before.Volumes):],
+			Secret:    readableSecret(newSecret),
+			Warnings:  append(warnings, annotationWarnings...),
+			Mounts:    addedMounts(before, spec),
+			// 离线渲染无法检测集群版本,auto时按普通容器注入
+			NativeSidecar: cfg.Sidecar.NativeSidecar == container.NativeEnabled,
+		})
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("清单中没有Deployment、StatefulSet、DaemonSet、Job或CronJob")
+	}
+	return results, nil
+}
+
+// writeRendered 输出渲染结果,yaml格式下多个工作负载以---分隔,json格式下多个工作负载输出为List
+func writeRendered(w io.Writer, results []renderResult, format string) error {
+	if format == manifest.FormatJSON && len(results) > 1 {
+		out, err := manifest.Marshal(renderList{APIVersion: "v1", Kind: "List", Items: results}, format)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	}
+	for i, result := range results {
+		out, err := manifest.Marshal(result, format)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		if _, err = w.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readableSecret 将secret的Data转换为StringData便于阅读
+func readableSecret(s *corev1.Secret) *corev1.Secret {
+	out := s.DeepCopy()
+	out.StringData = make(map[string]string, len(out.Data))
+	for k, v := range out.Data {
+		out.StringData[k] = string(v)
+	}
+	out.Data = nil
+	return out
+}
+
+// addedMounts 获取注入后应用容器新增的卷挂载路径
+func addedMounts(before, after *corev1.PodSpec) map[string][]string {
+	mounts := make(map[string][]string)
+	for i, c := range before.Containers {
+		for _, m := range after.Containers[i].VolumeMounts[len(c.VolumeMounts):] {
+			mounts[c.Name] = append(mounts[c.Name], m.Name+":"+m.MountPath)
+		}
+	}
+	return mounts
+}
+
+// injectWarnings 检查工作负载是否会被控制器注入
+func injectWarnings(cfg *config.Config, meta *metav1.ObjectMeta, spec *corev1.PodSpec) []string {
+	switch deploy.ExcludedReason(*meta, *spec, cfg.Sidecar.Name, *cfg.WhiteList) {
+	case deploy.ReasonNotAnnotated:
+		return []string{"工作负载未设置注释 " + deploy.AnnotationSidecar + ": 'true',控制器不会注入sidecar"}
+	case deploy.ReasonNamespaceWhiteList:
+		return []string{"namespace " + meta.Namespace + " 在白名单中,控制器不会注入sidecar"}
+	case deploy.ReasonWorkloadWhiteList:
+		return []string{"工作负载 " + meta.Name + " 在白名单中,控制器不会注入sidecar"}
+	case deploy.ReasonAlreadyInjected:
+		return []string{"工作负载已经注入sidecar容器 " + cfg.Sidecar.Name}
+	default:
+		return nil
+	}
+}
+
+// 注册到rootCmd
+func init() {
+	RenderKubeSidecar.Flags().StringVarP(&renderFilename, "filename", "f", "-", "Manifest file to render, - for stdin")
+	RenderKubeSidecar.Flags().StringVarP(&renderOutput, "output", "o", manifest.FormatYAML, "Output format, yaml or json")
+	rootCmd.AddCommand(RenderKubeSidecar)
+}
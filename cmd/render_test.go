@@ -0,0 +1,118 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"kube-sidecar/config"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/manifest"
+	"kube-sidecar/pkg/model/secret"
+	"strings"
+	"testing"
+)
+
+// renderManifest 开启注入的工作负载清单
+const renderManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: %s
+  annotations:
+    deployment.kubernetes.io/sidecar: "true"
+    deployment.kubernetes.io/sidecar.backend: elasticsearch
+    deployment.kubernetes.io/sidecar.outputEsHost: es
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: nginx
+`
+
+func TestRender(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	deployment := func(name string) string {
+		return strings.Replace(renderManifest, "%s", name, 1)
+	}
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n"
+	tests := []struct {
+		name      string
+		manifest  string
+		format    string
+		workloads []string
+		wantErr   bool
+	}{
+		{name: "yaml", manifest: deployment("web"), format: manifest.FormatYAML, workloads: []string{"web"}},
+		{name: "json", manifest: deployment("web"), format: manifest.FormatJSON, workloads: []string{"web"}},
+		{name: "skip other kinds", manifest: configMap + "---\n" + deployment("web"), format: manifest.FormatYAML, workloads: []string{"web"}},
+		{name: "multiple yaml", manifest: deployment("web") + "---\n" + deployment("api"), format: manifest.FormatYAML, workloads: []string{"web", "api"}},
+		{name: "multiple json", manifest: deployment("web") + "---\n" + deployment("api"), format: manifest.FormatJSON, workloads: []string{"web", "api"}},
+		{name: "no workloads", manifest: configMap, format: manifest.FormatYAML, wantErr: true},
+		{name: "unknown format", manifest: deployment("web"), format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			results, err := renderManifests(config.New(), []byte(tt.manifest))
+			if err == nil {
+				err = writeRendered(&out, results, tt.format)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var rendered []renderResult
+			switch {
+			case tt.format == manifest.FormatJSON && len(tt.workloads) > 1:
+				// 多个工作负载输出为一个合法的json List
+				var list renderList
+				if err = json.Unmarshal(out.Bytes(), &list); err != nil {
+					t.Fatalf("output is not a single json document: %v\n%s", err, out.String())
+				}
+				if list.Kind != "List" {
+					t.Errorf("kind = %s, want List", list.Kind)
+				}
+				rendered = list.Items
+			case tt.format == manifest.FormatJSON:
+				var result renderResult
+				if err = json.Unmarshal(out.Bytes(), &result); err != nil {
+					t.Fatal(err)
+				}
+				rendered = []renderResult{result}
+			default:
+				if docs := manifest.Split(out.Bytes()); len(docs) != len(tt.workloads) {
+					t.Fatalf("yaml documents = %d, want %d", len(docs), len(tt.workloads))
+				}
+				rendered = results
+			}
+			if len(rendered) != len(tt.workloads) {
+				t.Fatalf("rendered %d workloads, want %d", len(rendered), len(tt.workloads))
+			}
+			for i, r := range rendered {
+				if r.Name != tt.workloads[i] || r.Namespace != "default" || r.Container.Name != config.New().Sidecar.Name {
+					t.Errorf("result %d = %s/%s container %s", i, r.Namespace, r.Name, r.Container.Name)
+				}
+				if r.Secret.Name != secret.Name(tt.workloads[i]) || r.Secret.StringData[secret.ConfigKey] == "" {
+					t.Errorf("secret = %+v", r.Secret)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"kube-sidecar/config"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/manifest"
+	"kube-sidecar/pkg/model/container"
+	"kube-sidecar/pkg/model/deploy"
+	"kube-sidecar/pkg/model/secret"
+	"kube-sidecar/utils/tools"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// reasonPending 工作负载开启了注入但尚未注入sidecar,可能注入失败或控制器尚未处理
+const reasonPending = "Pending"
+
+// reasonUnsupported 控制器只处理Deployment,StatefulSet与DaemonSet只能通过离线inject注入
+const reasonUnsupported = "Unsupported"
+
+// 定义status命令参数
+var (
+	statusNamespace string
+	statusOutput    string
+	kubeOptions     = kubernetes.NewKubernetesOptions()
+)
+
+// workloadStatus 定义工作负载注入状态
+type workloadStatus struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Injected  bool   `json:"injected"`
+	Image     string `json:"image,omitempty"`
+	Backend   string `json:"backend,omitempty"`
+	Secret    bool   `json:"secret"`
+	// ConfigInSync 集群中的secret是否与当前配置渲染的结果一致,secret不存在时为空
+	ConfigInSync *bool  `json:"configInSync,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// StatusKubeSidecar 列出集群中开启注入的工作负载的注入状态
+var StatusKubeSidecar = &cobra.Command{
+	Use:           "status",
+	Example:       "kube-sidecar status\nkube-sidecar status -n production -o json",
+	Short:         "List the sidecar injection state of opted-in workloads across the cluster",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfigFromFile()
+		if err != nil {
+			return err
+		}
+		client, err := kubernetes.NewKubernetesClient(kubeOptions)
+		if err != nil {
+			return err
+		}
+		cfg.Sidecar.NativeSidecar = container.ResolveNative(cfg.Sidecar.NativeSidecar, client)
+		statuses, err := collectStatus(cmd.Context(), cfg, client, statusNamespace)
+		if err != nil {
+			return err
+		}
+		if statusOutput != manifest.FormatTable {
+			out, err := manifest.Marshal(statuses, statusOutput)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(cmd.OutOrStdout(), string(out))
+			return nil
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tKIND\tNAME\tINJECTED\tIMAGE\tBACKEND\tSECRET\tIN-SYNC\tREASON")
+		for _, s := range statuses {
+			inSync := "-"
+			if s.ConfigInSync != nil {
+				inSync = strconv.FormatBool(*s.ConfigInSync)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%t\t%s\t%s\n", s.Namespace, s.Kind, s.Name, s.Injected,
+				dash(s.Image), dash(s.Backend), s.Secret, inSync, dash(s.Reason))
+		}
+		return w.Flush()
+	},
+}
+
+// collectStatus 获取namespace下开启注入的工作负载的注入状态
+func collectStatus(ctx context.Context, cfg *config.Config, client kubernetes.Client, namespace string) ([]workloadStatus, error) {
+	objects, err := listWorkloads(ctx, client, namespace)
+	if err != nil {
+		return nil, err
+	}
+	d := deploy.NewDeploy(kubernetes.NewNullClient(), *cfg.FluentBitConfig, *cfg.Sidecar, *cfg.Controller)
+	var statuses []workloadStatus
+	for _, obj := range objects {
+		meta, spec, _ := manifest.PodTemplate(obj)
+		if meta.Annotations[deploy.AnnotationSidecar] != "true" {
+			continue
+		}
+		status := workloadStatus{
+			Namespace: meta.Namespace,
+			Kind:      obj.GetObjectKind().GroupVersionKind().Kind,
+			Name:      meta.Name,
+			Backend:   meta.Annotations["deployment.kubernetes.io/sidecar.backend"],
+		}
+		marker, _, _ := deploy.ParseMarker(meta.Annotations)
+		sidecarName := tools.SetDefaultValueNotExist(marker.Container, cfg.Sidecar.Name)
+		if c := findContainer(spec, sidecarName); c != nil {
+			status.Injected = true
+			status.Image = c.Image
+		} else if status.Kind != "Deployment" {
+			status.Reason = reasonUnsupported
+		} else if status.Reason = deploy.ExcludedReason(*meta, *spec, cfg.Sidecar.Name, *cfg.WhiteList); status.Reason == "" {
+			status.Reason = reasonPending
+		}
+		secretName := tools.SetDefaultValueNotExist(marker.Secret, secret.Name(meta.Name))
+		live, err := client.Kubernetes().CoreV1().Secrets(meta.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+		case err != nil:
+			return nil, err
+		default:
+			status.Secret = true
+			desired, err := desiredState(d, meta, spec, cfg.Sidecar.Name)
+			if err != nil {
+				status.Error = err.Error()
+				break
+			}
+			inSync := secret.Hash(desired.Secret.Data) == secret.Hash(live.Data)
+			status.ConfigInSync = &inSync
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// desiredWorkload 定义工作负载按当前配置注入后的期望状态
+type desiredWorkload struct {
+	Meta   *metav1.ObjectMeta
+	Spec   *corev1.PodSpec
+	Secret *corev1.Secret
+}
+
+// desiredState 移除已注入的sidecar后按当前配置重新注入,计算工作负载的期望状态,不修改传入的对象
+func desiredState(d deploy.Deploy, meta *metav1.ObjectMeta, spec *corev1.PodSpec, sidecarName string) (*desiredWorkload, error) {
+	desired := &desiredWorkload{
+		Meta: meta.DeepCopy(),
+		Spec: spec.DeepCopy(),
+	}
+	if _, err := deploy.Uninject(desired.Meta, desired.Spec, sidecarName); err != nil {
+		return nil, err
+	}
+	newSecret, _, err := d.Inject(desired.Meta, desired.Spec)
+	if err != nil {
+		return nil, err
+	}
+	desired.Secret = newSecret
+	return desired, nil
+}
+
+// listWorkloads 获取集群中的Deployment、StatefulSet与DaemonSet,按namespace与名称排序
+func listWorkloads(ctx context.Context, client kubernetes.Client, namespace string) ([]runtime.Object, error) {
+	apps := client.Kubernetes().AppsV1()
+	var objects []runtime.Object
+	deployments, err := apps.Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		deployments.Items[i].Kind = "Deployment"
+		objects = append(objects, &deployments.Items[i])
+	}
+	statefulSets, err := apps.StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		statefulSets.Items[i].Kind = "StatefulSet"
+		objects = append(objects, &statefulSets.Items[i])
+	}
+	daemonSets, err := apps.DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		daemonSets.Items[i].Kind = "DaemonSet"
+		objects = append(objects, &daemonSets.Items[i])
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		mi, _, _ := manifest.PodTemplate(objects[i])
+		mj, _, _ := manifest.PodTemplate(objects[j])
+		if mi.Namespace != mj.Namespace {
+			return mi.Namespace < mj.Namespace
+		}
+		return mi.Name < mj.Name
+	})
+	return objects, nil
+}
+
+// findContainer 在pod模版的容器与初始化容器中查找指定名称的容器
+func findContainer(spec *corev1.PodSpec, name string) *corev1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return &spec.Containers[i]
+		}
+	}
+	for i := range spec.InitContainers {
+		if spec.InitContainers[i].Name == name {
+			return &spec.InitContainers[i]
+		}
+	}
+	return nil
+}
+
+// dash 空值在表格中显示为-
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// 注册到rootCmd
+func init() {
+	StatusKubeSidecar.Flags().StringVarP(&statusNamespace, "namespace", "n", metav1.NamespaceAll, "Only list workloads in this namespace, all namespaces if empty")
+	StatusKubeSidecar.Flags().StringVarP(&statusOutput, "output", "o", manifest.FormatTable, "Output format, table, json or yaml")
+	kubeOptions.AddFlags(StatusKubeSidecar.Flags(), kubeOptions)
+	rootCmd.AddCommand(StatusKubeSidecar)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"kube-sidecar/config"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/model/deploy"
+	"testing"
+)
+
+func TestCollectStatusUnsupportedKinds(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "shop", Annotations: map[string]string{deploy.AnnotationSidecar: "true"}}
+	}
+	template := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}}}
+	client := kubernetes.NewFakeClientSets(fake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: meta("web"), Spec: appsv1.DeploymentSpec{Template: template}},
+		&appsv1.StatefulSet{ObjectMeta: meta("db"), Spec: appsv1.StatefulSetSpec{Template: template}},
+		&appsv1.DaemonSet{ObjectMeta: meta("agent"), Spec: appsv1.DaemonSetSpec{Template: template}},
+	), nil, nil, "", nil)
+	statuses, err := collectStatus(context.Background(), config.New(), client, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"web": reasonPending, "db": reasonUnsupported, "agent": reasonUnsupported}
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	for _, s := range statuses {
+		if s.Reason != want[s.Name] {
+			t.Errorf("%s %s reason = %s, want %s", s.Kind, s.Name, s.Reason, want[s.Name])
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"kube-sidecar/pkg/manifest"
+	"os"
+)
+
+// 定义uninject命令参数
+var uninjectFilename string
+
+// UninjectKubeSidecar 离线移除inject命令添加的sidecar容器、卷、卷挂载与fluentBit secret
+var UninjectKubeSidecar = &cobra.Command{
+	Use:           "uninject",
+	Example:       "kube-sidecar uninject -f injected.yaml > manifests.yaml",
+	Short:         "Remove the sidecar injected by kube-sidecar from a multi-document manifest offline",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := readInput(cmd, uninjectFilename)
+		if err != nil {
+			return err
+		}
+		var docs []string
+		uninjected, removed := 0, 0
+		for _, doc := range manifest.Split(data) {
+			out, result, err := manifest.Uninject(doc)
+			if err != nil {
+				return err
+			}
+			switch result {
+			case manifest.Removed:
+				removed++
+				continue
+			case manifest.Uninjected:
+				uninjected++
+			}
+			docs = append(docs, out)
+		}
+		writeDocs(cmd.OutOrStdout(), docs)
+		fmt.Fprintf(os.Stderr, "已从%d个工作负载移除sidecar,删除%d个secret\n", uninjected, removed)
+		return nil
+	},
+}
+
+// 注册到rootCmd
+func init() {
+	UninjectKubeSidecar.Flags().StringVarP(&uninjectFilename, "filename", "f", "-", "Manifest file to uninject, - for stdin")
+	rootCmd.AddCommand(UninjectKubeSidecar)
+}
@@ -20,14 +20,19 @@ import (
 	"errors"
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
-	"github.com/wonderivan/logger"
+	"go.uber.org/zap"
 	"k8s.io/client-go/util/homedir"
+	"kube-sidecar/pkg/clientset/controller"
 	"kube-sidecar/pkg/clientset/fluent"
 	"kube-sidecar/pkg/clientset/jaeger"
 	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/clientset/monitoring"
 	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/clientset/tracing"
 	"kube-sidecar/pkg/clientset/version"
 	"kube-sidecar/pkg/clientset/workload"
+	"kube-sidecar/pkg/metrics"
+	"log"
 	"path/filepath"
 )
 
@@ -38,12 +43,15 @@ const (
 
 // Config 定义全局config结构体
 type Config struct {
-	LoggingConfig   *logging.Options  `json:"loggingConfig,omitempty" yaml:"loggingConfig,omitempty" xml:"loggingConfig,omitempty" mapstructure:"loggingConfig"`
-	JaegerConfig    *jaeger.Options   `yaml:"jaegerConfig,omitempty" xml:"jaegerConfig,omitempty" json:"jaegerConfig,omitempty" mapstructure:"jaegerConfig"`
-	Sidecar         *sidecar.Options  `json:"sidecar,omitempty" yaml:"sidecar,omitempty" xml:"sidecar,omitempty" mapstructure:"sidecar"`
-	WhiteList       *workload.Options `json:"whiteList,omitempty" xml:"whiteList,omitempty" yaml:"whiteList,omitempty" mapstructure:"whiteList"`
-	FluentBitConfig *fluent.Options   `json:"fluentBitConfig,omitempty" yaml:"fluentBitConfig,omitempty" xml:"fluentBitConfig,omitempty" mapstructure:"fluentBitConfig"`
-	Version         *version.Options  `json:"version,omitempty" xml:"version,omitempty" yaml:"version,omitempty" mapstructure:"version"`
+	LoggingConfig   *logging.Options    `json:"loggingConfig,omitempty" yaml:"loggingConfig,omitempty" xml:"loggingConfig,omitempty" mapstructure:"loggingConfig"`
+	Tracing         *tracing.Options    `json:"tracing,omitempty" yaml:"tracing,omitempty" xml:"tracing,omitempty" mapstructure:"tracing"`
+	JaegerConfig    *jaeger.Options     `yaml:"jaegerConfig,omitempty" xml:"jaegerConfig,omitempty" json:"jaegerConfig,omitempty" mapstructure:"jaegerConfig"`
+	Sidecar         *sidecar.Options    `json:"sidecar,omitempty" yaml:"sidecar,omitempty" xml:"sidecar,omitempty" mapstructure:"sidecar"`
+	WhiteList       *workload.Options   `json:"whiteList,omitempty" xml:"whiteList,omitempty" yaml:"whiteList,omitempty" mapstructure:"whiteList"`
+	FluentBitConfig *fluent.Options     `json:"fluentBitConfig,omitempty" yaml:"fluentBitConfig,omitempty" xml:"fluentBitConfig,omitempty" mapstructure:"fluentBitConfig"`
+	Version         *version.Options    `json:"version,omitempty" xml:"version,omitempty" yaml:"version,omitempty" mapstructure:"version"`
+	Controller      *controller.Options `json:"controller,omitempty" xml:"controller,omitempty" yaml:"controller,omitempty" mapstructure:"controller"`
+	Monitoring      *monitoring.Options `json:"monitoring,omitempty" xml:"monitoring,omitempty" yaml:"monitoring,omitempty" mapstructure:"monitoring"`
 }
 
 // LoadConfigFromFile 初始化配置文件
@@ -64,27 +72,38 @@ func LoadConfigFromFile() (*Config, error) {
 	viper.AutomaticEnv()
 
 	// 执行读取配置文件
+	found := true
 	if err := viper.ReadInConfig(); err != nil {
 		var configFileNotFoundError viper.ConfigFileNotFoundError
 		if errors.As(err, &configFileNotFoundError) {
-			logger.Error("配置文件" + workDir + "/conf.yaml 不存在!")
+			found = false
+			// 输出到标准错误,避免污染render等离线命令的标准输出
+			log.Println("配置文件" + workDir + "/config.yaml 不存在,使用默认配置!")
 		}
 	}
 	conf := New()
 	/* viper动态加载配置 */
 	// 监视配置文件是否发生更改
-	viper.WatchConfig()
-	// 处理配置变更事件
+	if found {
+		viper.WatchConfig()
+	}
+	// 处理配置变更事件,控制器持有配置的副本,只有日志级别可以运行中生效;
+	// 解析到新的Config对象,不修改/debug/config与就绪检查并发读取的生效配置
 	viper.OnConfigChange(func(in fsnotify.Event) {
-		// TODO: 联调测试
-		logger.Info("配置文件" + in.Name + "发生变更")
-		logger.Warn(conf.LoggingConfig.WriteLog)
-		// 重新初始化并生效配置
-		err := viper.Unmarshal(conf)
-		if err != nil {
-			logger.Error("数据反序列化失败,错误信息", err)
+		logging.Logger.Info("配置文件发生变更", zap.String("file", in.Name))
+		reloaded := New()
+		if err := viper.Unmarshal(reloaded); err != nil {
+			metrics.ConfigReloads.WithLabelValues(metrics.ResultError).Inc()
+			logging.Logger.Error("数据反序列化失败", zap.Error(err))
+			return
+		}
+		if err := logging.SetLevel(reloaded.LoggingConfig.Level); err != nil {
+			metrics.ConfigReloads.WithLabelValues(metrics.ResultError).Inc()
+			logging.Logger.Error("日志级别无效", zap.String("level", reloaded.LoggingConfig.Level), zap.Error(err))
 			return
 		}
+		metrics.ConfigReloads.WithLabelValues(metrics.ResultSuccess).Inc()
+		logging.Logger.Info("日志级别已生效,其他配置重启后生效", zap.String("level", reloaded.LoggingConfig.Level))
 	})
 	if err := viper.Unmarshal(conf); err != nil {
 		return nil, err
@@ -95,10 +114,13 @@ func LoadConfigFromFile() (*Config, error) {
 func New() *Config {
 	return &Config{
 		LoggingConfig:   logging.NewLoggingOptions(),
+		Tracing:         tracing.NewTracingOptions(),
 		JaegerConfig:    jaeger.NewJaegerOptions(),
 		Sidecar:         sidecar.NewSidecarOptions(),
 		Version:         version.NewVersionOptions(),
 		WhiteList:       workload.NewWhiteListOptions(),
 		FluentBitConfig: fluent.NewFluentBitOptions(),
+		Controller:      controller.NewControllerOptions(),
+		Monitoring:      monitoring.NewMonitoringOptions(),
 	}
 }
@@ -0,0 +1,64 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/spf13/viper"
+	"kube-sidecar/pkg/clientset/fluent"
+	"kube-sidecar/pkg/clientset/tracing"
+	"reflect"
+	"testing"
+)
+
+// TestLoadConfigFile 通过配置加载方法读取随镜像发布的conf/config.yaml,配置需要能解析并通过校验
+func TestLoadConfigFile(t *testing.T) {
+	viper.AddConfigPath("conf")
+	conf, err := LoadConfigFromFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file := viper.ConfigFileUsed(); file == "" {
+		t.Fatal("conf/config.yaml not loaded")
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		t.Errorf("Validate() = %v", errs)
+	}
+	if conf.Tracing.Exporter != tracing.ExporterNone {
+		t.Errorf("tracing.exporter = %s, want %s", conf.Tracing.Exporter, tracing.ExporterNone)
+	}
+	want := []tracing.Attribute{{Key: "k8s.cluster.name", Value: "kind"}}
+	if len(conf.Tracing.ResourceAttributes) != 1 || conf.Tracing.ResourceAttributes[0] != want[0] {
+		t.Errorf("tracing.resourceAttributes = %v, want %v", conf.Tracing.ResourceAttributes, want)
+	}
+}
+
+// TestLoadFluentBitConfig conf/config.yaml中fluentBitConfig下的全局FILTER与自定义脱敏规则需要被加载
+func TestLoadFluentBitConfig(t *testing.T) {
+	viper.AddConfigPath("conf")
+	conf, err := LoadConfigFromFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := []fluent.Filter{{Name: "grep", Exclude: []string{"log /healthz"}}}
+	if !reflect.DeepEqual(conf.FluentBitConfig.Filters, filters) {
+		t.Errorf("fluentBitConfig.filters = %+v, want %+v", conf.FluentBitConfig.Filters, filters)
+	}
+	rules := []fluent.MaskRule{{Name: "password", Pattern: "(password=)%S+", Replacement: "%1***"}}
+	if !reflect.DeepEqual(conf.FluentBitConfig.MaskCustomRules, rules) {
+		t.Errorf("fluentBitConfig.maskCustomRules = %+v, want %+v", conf.FluentBitConfig.MaskCustomRules, rules)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/model/container"
+)
+
+// Validate 校验生效配置,返回全部无效的配置项
+func (c *Config) Validate() []error {
+	var errs []error
+	if c.Sidecar == nil || c.FluentBitConfig == nil || c.Controller == nil {
+		return append(errs, fmt.Errorf("配置不完整"))
+	}
+	if c.Sidecar.Name == "" {
+		errs = append(errs, fmt.Errorf("sidecar.name不能为空"))
+	}
+	if c.Sidecar.Image == "" {
+		errs = append(errs, fmt.Errorf("sidecar.image不能为空"))
+	}
+	for field, value := range map[string]string{
+		"sidecar.requestsCPU":    c.Sidecar.RequestsCPU,
+		"sidecar.requestsMemory": c.Sidecar.RequestsMemory,
+		"sidecar.limitCPU":       c.Sidecar.LimitCPU,
+		"sidecar.limitMemory":    c.Sidecar.LimitMemory,
+		"sidecar.minCPU":         c.Sidecar.MinCPU,
+		"sidecar.maxCPU":         c.Sidecar.MaxCPU,
+		"sidecar.minMemory":      c.Sidecar.MinMemory,
+		"sidecar.maxMemory":      c.Sidecar.MaxMemory,
+	} {
+		if value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s的值%s无效: %w", field, value, err))
+		}
+	}
+	switch c.Sidecar.NativeSidecar {
+	case container.NativeAuto, container.NativeEnabled, container.NativeDisabled:
+	default:
+		errs = append(errs, fmt.Errorf("sidecar.nativeSidecar的值%s无效,可选auto、true、false", c.Sidecar.NativeSidecar))
+	}
+	if c.LoggingConfig != nil {
+		if _, err := zapcore.ParseLevel(c.LoggingConfig.Level); err != nil {
+			errs = append(errs, fmt.Errorf("loggingConfig.level的值%s无效: %w", c.LoggingConfig.Level, err))
+		}
+		switch c.LoggingConfig.Format {
+		case "", logging.FormatConsole, logging.FormatJSON:
+		default:
+			errs = append(errs, fmt.Errorf("loggingConfig.format的值%s无效,可选console、json", c.LoggingConfig.Format))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+// Options 定义控制器全局配置结构体
+type Options struct {
+	// DryRun 开启后控制器对kubernetes的写操作均使用服务端dry-run,只记录日志与事件,不实际修改集群
+	DryRun bool `json:"dryRun,omitempty" xml:"dryRun,omitempty" yaml:"dryRun,omitempty" describe:"只观察不修改集群"`
+	// MetricsAddress 控制器指标服务监听地址,为空则不启动
+	MetricsAddress string `json:"metricsAddress,omitempty" xml:"metricsAddress,omitempty" yaml:"metricsAddress,omitempty" describe:"指标服务监听地址"`
+	// HealthAddress 健康检查与调试服务监听地址,暴露/healthz、/readyz与/debug/config,为空则不启动
+	HealthAddress string `json:"healthAddress,omitempty" xml:"healthAddress,omitempty" yaml:"healthAddress,omitempty" describe:"健康检查服务监听地址"`
+	// Pprof 是否在健康检查服务上开启/debug/pprof
+	Pprof bool `json:"pprof,omitempty" xml:"pprof,omitempty" yaml:"pprof,omitempty" describe:"开启pprof"`
+	// LogLevelEndpoint 是否在健康检查服务上开启/debug/loglevel,接口没有认证,开启后集群内可以修改控制器日志级别
+	LogLevelEndpoint bool `json:"logLevelEndpoint,omitempty" xml:"logLevelEndpoint,omitempty" yaml:"logLevelEndpoint,omitempty" describe:"开启运行中修改日志级别的接口"`
+}
+
+func NewControllerOptions() *Options {
+	return &Options{
+		MetricsAddress: ":8080",
+		HealthAddress:  ":8081",
+	}
+}
@@ -16,19 +16,30 @@ limitations under the License.
 
 package elastic
 
+import (
+	"kube-sidecar/pkg/clientset/fluent"
+)
+
 // OutputElasticsearch output为elasticsearch的fluentBit配置结构体
 type OutputElasticsearch struct {
 	ServiceLogLevel string `json:"serviceLogLevel,omitempty" yaml:"serviceLogLevel,omitempty" xml:"serviceLogLevel,omitempty" describe:"fluentBit日志level,默认info"`
+	ServiceGrace    int    `json:"serviceGrace,omitempty" yaml:"serviceGrace,omitempty" xml:"serviceGrace,omitempty" describe:"收到SIGTERM后刷新缓冲数据的最长时间(秒)"`
 	// Service          FluentBitService `json:"service,omitempty" xml:"service,omitempty" yaml:"service,omitempty" describe:"fluentBit日志level,默认info"`
 	// Input            FluentBitInput `json:"input,omitempty" xml:"input,omitempty" yaml:"input,omitempty" describe:"fluentBit INPUT"`
-	InputAppName         string `json:"inputAppName,omitempty" yaml:"inputAppName,omitempty" xml:"inputAppName,omitempty" describe:"采集日志的应用名称"`
-	InputLogPath         string `json:"inputLogPath,omitempty" yaml:"inputLogPath,omitempty" xml:"inputLogPath,omitempty" describe:"采集日志路劲"`
-	InputAppTag          string `json:"inputAppTag,omitempty" yaml:"inputAppTag,omitempty" xml:"inputAppTag,omitempty" describe:"采集日志的应用Tag"`
-	InputMemBufLimit     string `json:"inputMemBufLimit,omitempty" yaml:"inputMemBufLimit,omitempty" xml:"inputMemBufLimit,omitempty" describe:"采集日志的应用Tag"`
-	InputRefreshInterval int    `json:"inputRefreshInterval,omitempty" yaml:"inputRefreshInterval,omitempty" xml:"inputRefreshInterval,omitempty" describe:"采集日志刷新间隔"`
-	OutputEsHost         string `json:"outputEsHost,omitempty" yaml:"outputEsHost,omitempty" xml:"outputEsHost,omitempty" describe:"elasticsearch数据库地址"`
-	OutputEsPort         string `json:"outputEsPort,omitempty" yaml:"outputEsPort,omitempty" xml:"outputEsPort,omitempty" describe:"elasticsearch数据库端口"`
-	OutputEsIndex        string `json:"outputEsIndex,omitempty" yaml:"outputEsIndex,omitempty" xml:"outputEsIndex,omitempty" describe:"elasticsearch数据库index"`
-	OutputEsUser         string `json:"outputEsUser,omitempty" yaml:"outputEsUser,omitempty" xml:"outputEsUser,omitempty" describe:"elasticsearch数据库user"`
-	OutputEsPassword     string `json:"outputEsPassword,omitempty" yaml:"outputEsPassword,omitempty" xml:"outputEsPassword,omitempty" describe:"elasticsearch数据库password"`
+	InputAppName           string          `json:"inputAppName,omitempty" yaml:"inputAppName,omitempty" xml:"inputAppName,omitempty" describe:"采集日志的应用名称"`
+	InputLogPath           string          `json:"inputLogPath,omitempty" yaml:"inputLogPath,omitempty" xml:"inputLogPath,omitempty" describe:"采集日志路劲"`
+	InputAppTag            string          `json:"inputAppTag,omitempty" yaml:"inputAppTag,omitempty" xml:"inputAppTag,omitempty" describe:"采集日志的应用Tag"`
+	InputMemBufLimit       string          `json:"inputMemBufLimit,omitempty" yaml:"inputMemBufLimit,omitempty" xml:"inputMemBufLimit,omitempty" describe:"采集日志的应用Tag"`
+	InputRefreshInterval   int             `json:"inputRefreshInterval,omitempty" yaml:"inputRefreshInterval,omitempty" xml:"inputRefreshInterval,omitempty" describe:"采集日志刷新间隔"`
+	OutputEsHost           string          `json:"outputEsHost,omitempty" yaml:"outputEsHost,omitempty" xml:"outputEsHost,omitempty" describe:"elasticsearch数据库地址"`
+	OutputEsPort           string          `json:"outputEsPort,omitempty" yaml:"outputEsPort,omitempty" xml:"outputEsPort,omitempty" describe:"elasticsearch数据库端口"`
+	OutputEsIndex          string          `json:"outputEsIndex,omitempty" yaml:"outputEsIndex,omitempty" xml:"outputEsIndex,omitempty" describe:"elasticsearch数据库index"`
+	OutputEsUser           string          `json:"outputEsUser,omitempty" yaml:"outputEsUser,omitempty" xml:"outputEsUser,omitempty" describe:"elasticsearch数据库user"`
+	OutputEsPassword       string          `json:"outputEsPassword,omitempty" yaml:"outputEsPassword,omitempty" xml:"outputEsPassword,omitempty" describe:"elasticsearch数据库password"`
+	StoragePath            string          `json:"storagePath,omitempty" yaml:"storagePath,omitempty" xml:"storagePath,omitempty" describe:"位置数据库与文件缓冲目录"`
+	StorageType            string          `json:"storageType,omitempty" yaml:"storageType,omitempty" xml:"storageType,omitempty" describe:"缓冲类型,memory或filesystem"`
+	StorageBacklogMemLimit string          `json:"storageBacklogMemLimit,omitempty" yaml:"storageBacklogMemLimit,omitempty" xml:"storageBacklogMemLimit,omitempty" describe:"积压数据加载到内存的上限"`
+	StorageMaxChunksUp     int             `json:"storageMaxChunksUp,omitempty" yaml:"storageMaxChunksUp,omitempty" xml:"storageMaxChunksUp,omitempty" describe:"内存中最多保留的chunk数量"`
+	StorageTotalLimitSize  string          `json:"storageTotalLimitSize,omitempty" yaml:"storageTotalLimitSize,omitempty" xml:"storageTotalLimitSize,omitempty" describe:"output文件缓冲总大小上限"`
+	Filters                []fluent.Filter `json:"filters,omitempty" yaml:"filters,omitempty" xml:"filters,omitempty" describe:"FILTER处理流水线"`
 }
@@ -0,0 +1,176 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fluent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 支持的FILTER插件类型
+const (
+	FilterGrep           = "grep"
+	FilterModify         = "modify"
+	FilterRecordModifier = "record_modifier"
+	FilterNest           = "nest"
+	FilterLua            = "lua"
+)
+
+// Filter 定义fluentBit FILTER配置结构体,键值类配置使用fluentBit原生的"key value"格式
+type Filter struct {
+	Name  string `json:"name,omitempty" yaml:"name,omitempty" xml:"name,omitempty" describe:"FILTER插件类型,grep/modify/record_modifier/nest/lua"`
+	Match string `json:"match,omitempty" yaml:"match,omitempty" xml:"match,omitempty" describe:"匹配的Tag,默认为应用日志Tag"`
+	// grep
+	Regex   []string `json:"regex,omitempty" yaml:"regex,omitempty" xml:"regex,omitempty" describe:"保留匹配的记录,格式为key regex"`
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty" xml:"exclude,omitempty" describe:"丢弃匹配的记录,格式为key regex"`
+	// modify
+	Add    []string `json:"add,omitempty" yaml:"add,omitempty" xml:"add,omitempty" describe:"新增字段,格式为key value"`
+	Rename []string `json:"rename,omitempty" yaml:"rename,omitempty" xml:"rename,omitempty" describe:"重命名字段,格式为old new"`
+	Remove []string `json:"remove,omitempty" yaml:"remove,omitempty" xml:"remove,omitempty" describe:"删除字段"`
+	// record_modifier
+	Record       []string `json:"record,omitempty" yaml:"record,omitempty" xml:"record,omitempty" describe:"追加字段,格式为key value"`
+	RemoveKey    []string `json:"removeKey,omitempty" yaml:"removeKey,omitempty" xml:"removeKey,omitempty" describe:"删除字段"`
+	AllowlistKey []string `json:"allowlistKey,omitempty" yaml:"allowlistKey,omitempty" xml:"allowlistKey,omitempty" describe:"仅保留的字段"`
+	// nest
+	Operation    string   `json:"operation,omitempty" yaml:"operation,omitempty" xml:"operation,omitempty" describe:"nest或lift"`
+	Wildcard     []string `json:"wildcard,omitempty" yaml:"wildcard,omitempty" xml:"wildcard,omitempty" describe:"nest时匹配的字段"`
+	NestUnder    string   `json:"nestUnder,omitempty" yaml:"nestUnder,omitempty" xml:"nestUnder,omitempty" describe:"nest时嵌套到的字段"`
+	NestedUnder  string   `json:"nestedUnder,omitempty" yaml:"nestedUnder,omitempty" xml:"nestedUnder,omitempty" describe:"lift时展开的字段"`
+	AddPrefix    string   `json:"addPrefix,omitempty" yaml:"addPrefix,omitempty" xml:"addPrefix,omitempty" describe:"字段增加前缀"`
+	RemovePrefix string   `json:"removePrefix,omitempty" yaml:"removePrefix,omitempty" xml:"removePrefix,omitempty" describe:"字段移除前缀"`
+	// lua
+	Script string `json:"script,omitempty" yaml:"script,omitempty" xml:"script,omitempty" describe:"lua脚本内容,保存在生成的secret中"`
+	Call   string `json:"call,omitempty" yaml:"call,omitempty" xml:"call,omitempty" describe:"lua脚本调用的函数名称"`
+}
+
+// Entry 定义FILTER配置块中的一行配置
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// NewKubernetesMetadataFilter 生成为每条记录追加kubernetes元数据的record_modifier filter
+// pod与node信息来自sidecar容器通过Downward API注入的环境变量
+func NewKubernetesMetadataFilter(namespace, workload string) Filter {
+	return Filter{
+		Name: FilterRecordModifier,
+		Record: []string{
+			"k8s.namespace " + namespace,
+			"k8s.workload " + workload,
+			"k8s.pod ${POD_NAME}",
+			"k8s.node ${NODE_NAME}",
+		},
+	}
+}
+
+// ScriptName lua脚本在secret中的文件名称
+func ScriptName(index int) string {
+	return fmt.Sprintf("filter-%d.lua", index)
+}
+
+// Validate 校验FILTER配置,除lua脚本外的配置按行渲染到fluent-bit.conf,
+// 包含换行时可以注入任意配置段,例如额外的[OUTPUT],直接拒绝
+func (f Filter) Validate() error {
+	for _, v := range f.values() {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("%s filter的配置%q不能包含换行", f.Name, v)
+		}
+	}
+	switch f.Name {
+	case FilterGrep:
+		if len(f.Regex) == 0 && len(f.Exclude) == 0 {
+			return fmt.Errorf("grep filter需要配置regex或exclude")
+		}
+	case FilterModify:
+		if len(f.Add) == 0 && len(f.Rename) == 0 && len(f.Remove) == 0 {
+			return fmt.Errorf("modify filter需要配置add、rename或remove")
+		}
+	case FilterRecordModifier:
+		if len(f.Record) == 0 && len(f.RemoveKey) == 0 && len(f.AllowlistKey) == 0 {
+			return fmt.Errorf("record_modifier filter需要配置record、removeKey或allowlistKey")
+		}
+	case FilterNest:
+		switch f.Operation {
+		case "nest":
+			if len(f.Wildcard) == 0 || f.NestUnder == "" {
+				return fmt.Errorf("nest filter需要配置wildcard与nestUnder")
+			}
+		case "lift":
+			if f.NestedUnder == "" {
+				return fmt.Errorf("lift filter需要配置nestedUnder")
+			}
+		default:
+			return fmt.Errorf("nest filter不支持的operation %s", f.Operation)
+		}
+	case FilterLua:
+		if f.Script == "" || f.Call == "" {
+			return fmt.Errorf("lua filter需要配置script与call")
+		}
+	default:
+		return fmt.Errorf("不支持的filter类型 %s", f.Name)
+	}
+	return nil
+}
+
+// values 获取渲染到fluent-bit.conf的全部配置值
+func (f Filter) values() []string {
+	values := []string{f.Name, f.Match, f.Operation, f.NestUnder, f.NestedUnder, f.AddPrefix, f.RemovePrefix, f.Call}
+	for _, list := range [][]string{f.Regex, f.Exclude, f.Add, f.Rename, f.Remove, f.Record, f.RemoveKey, f.AllowlistKey, f.Wildcard} {
+		values = append(values, list...)
+	}
+	return values
+}
+
+// Entries 按fluentBit配置顺序生成FILTER配置块,index为该filter在pipeline中的序号
+func (f Filter) Entries(match string, index int) []Entry {
+	if f.Match != "" {
+		match = f.Match
+	}
+	entries := []Entry{{"Name", f.Name}, {"Match", match}}
+	add := func(key string, values ...string) {
+		for _, v := range values {
+			if v != "" {
+				entries = append(entries, Entry{key, v})
+			}
+		}
+	}
+	switch f.Name {
+	case FilterGrep:
+		add("Regex", f.Regex...)
+		add("Exclude", f.Exclude...)
+	case FilterModify:
+		add("Add", f.Add...)
+		add("Rename", f.Rename...)
+		add("Remove", f.Remove...)
+	case FilterRecordModifier:
+		add("Record", f.Record...)
+		add("Remove_key", f.RemoveKey...)
+		add("Allowlist_key", f.AllowlistKey...)
+	case FilterNest:
+		add("Operation", f.Operation)
+		add("Wildcard", f.Wildcard...)
+		add("Nest_under", f.NestUnder)
+		add("Nested_under", f.NestedUnder)
+		add("Add_prefix", f.AddPrefix)
+		add("Remove_prefix", f.RemovePrefix)
+	case FilterLua:
+		// lua脚本与fluent-bit.conf挂载在同一目录,使用相对路径引用
+		add("script", ScriptName(index))
+		add("call", f.Call)
+	}
+	return entries
+}
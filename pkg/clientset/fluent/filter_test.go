@@ -0,0 +1,40 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fluent
+
+import "testing"
+
+func TestFilterValidateRejectsNewlines(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr bool
+	}{
+		{"valid grep", Filter{Name: FilterGrep, Exclude: []string{"log /healthz"}}, false},
+		{"output injection", Filter{Name: FilterModify, Add: []string{"env prod\n[OUTPUT]\n    Name http\n    Host attacker"}}, true},
+		{"carriage return in match", Filter{Name: FilterGrep, Match: "app.*\r[OUTPUT]", Regex: []string{"log error"}}, true},
+		{"newline in nest prefix", Filter{Name: FilterNest, Operation: "lift", NestedUnder: "k8s", AddPrefix: "k8s_\n"}, true},
+		{"multi-line lua script", Filter{Name: FilterLua, Script: "function f(tag, ts, record)\n  return 0, ts, record\nend", Call: "f"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fluent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 内置脱敏规则名称
+const (
+	MaskEmail      = "email"
+	MaskBearer     = "bearer"
+	MaskCreditCard = "creditcard"
+	MaskIP         = "ip"
+)
+
+// MaskCall 脱敏lua脚本的入口函数名称
+const MaskCall = "kube_sidecar_mask"
+
+// MaskRule 定义脱敏规则结构体,fluentBit的lua filter不支持正则表达式,Pattern使用lua pattern语法:
+// 没有分支(|)与重复次数({n}),%d匹配数字,%w匹配字母数字,-为最短匹配,特殊字符使用%转义
+type MaskRule struct {
+	Name        string `json:"name,omitempty" yaml:"name,omitempty" xml:"name,omitempty" describe:"规则名称"`
+	Pattern     string `json:"pattern,omitempty" yaml:"pattern,omitempty" xml:"pattern,omitempty" describe:"lua pattern匹配表达式"`
+	Replacement string `json:"replacement,omitempty" yaml:"replacement,omitempty" xml:"replacement,omitempty" describe:"替换内容,支持%1引用捕获组"`
+	// Check 内置规则匹配后的校验函数,lua pattern无法表达边界与取值范围,匹配内容前后紧邻字母数字
+	// 或数字分隔的.时不脱敏,校验不通过时也不脱敏
+	Check string `json:"-" yaml:"-" xml:"-"`
+}
+
+// 内置规则的校验函数
+const (
+	// CheckLuhn 银行卡号通过Luhn校验
+	CheckLuhn = "luhn"
+	// CheckIPv4 IP地址每段不大于255
+	CheckIPv4 = "ipv4"
+)
+
+// BuiltinMaskRules 内置脱敏规则,按MaskRuleOrder顺序执行
+var BuiltinMaskRules = map[string]MaskRule{
+	MaskBearer: {
+		Name:        MaskBearer,
+		Pattern:     `([Bb]earer%s+)[%w%-%._~%+/]+=*`,
+		Replacement: `%1***`,
+	},
+	MaskEmail: {
+		Name:        MaskEmail,
+		Pattern:     `[%w%.%%%+%-_]+@[%w%-]+%.[%w%.%-]*%a`,
+		Replacement: `***@***`,
+	},
+	MaskCreditCard: {
+		Name:        MaskCreditCard,
+		Pattern:     `%d%d%d%d[ %-]?%d%d%d%d[ %-]?%d%d%d%d[ %-]?%d%d%d%d`,
+		Replacement: `****-****-****-****`,
+		Check:       CheckLuhn,
+	},
+	MaskIP: {
+		Name:        MaskIP,
+		Pattern:     `%d%d?%d?%.%d%d?%d?%.%d%d?%d?%.%d%d?%d?`,
+		Replacement: `*.*.*.*`,
+		Check:       CheckIPv4,
+	},
+}
+
+// MaskRuleOrder 内置脱敏规则执行顺序,银行卡号需要在IP之前处理
+var MaskRuleOrder = []string{MaskBearer, MaskEmail, MaskCreditCard, MaskIP}
+
+// maskScript 脱敏lua脚本模版,对记录中所有字符串字段依次执行脱敏规则
+const maskScript = `local rules = {
+%s}
+
+local checks = {
+    luhn = function(s)
+        local digits = string.gsub(s, "%%D", "")
+        local sum, double = 0, false
+        for i = #digits, 1, -1 do
+            local d = tonumber(string.sub(digits, i, i))
+            if double then
+                d = d * 2
+                if d > 9 then d = d - 9 end
+            end
+            sum = sum + d
+            double = not double
+        end
+        return sum %% 10 == 0
+    end,
+    ipv4 = function(s)
+        for octet in string.gmatch(s, "%%d+") do
+            if tonumber(octet) > 255 then return false end
+        end
+        return true
+    end,
+}
+
+-- bounded 匹配内容前后不能紧邻字母数字,也不能是数字分隔的.,例如版本号1.2.3.4.5与trace id中的数字
+local function bounded(value, i, j)
+    local before, after = string.sub(value, i - 1, i - 1), string.sub(value, j, j)
+    if string.find(before, "%%w") or string.find(after, "%%w") then return false end
+    if before == "." and string.find(string.sub(value, i - 2, i - 2), "%%d") then return false end
+    if after == "." and string.find(string.sub(value, j + 1, j + 1), "%%d") then return false end
+    return true
+end
+
+local function mask(value)
+    for _, rule in ipairs(rules) do
+        if rule.check then
+            local current = value
+            value = string.gsub(current, "()" .. rule.pattern .. "()", function(i, j)
+                if bounded(current, i, j) and checks[rule.check](string.sub(current, i, j - 1)) then
+                    return rule.replacement
+                end
+            end)
+        else
+            value = string.gsub(value, rule.pattern, rule.replacement)
+        end
+    end
+    return value
+end
+
+function %s(tag, timestamp, record)
+    local modified = false
+    for key, value in pairs(record) do
+        if type(value) == "string" then
+            local masked = mask(value)
+            if masked ~= value then
+                record[key] = masked
+                modified = true
+            end
+        end
+    end
+    if modified then
+        return 2, timestamp, record
+    end
+    return 0, timestamp, record
+end
+`
+
+// MaskRules 根据启用的内置规则名称与自定义规则生成脱敏规则列表
+func MaskRules(names []string, custom []MaskRule) ([]MaskRule, error) {
+	var rules []MaskRule
+	for _, name := range MaskRuleOrder {
+		for _, n := range names {
+			if n == name {
+				rules = append(rules, BuiltinMaskRules[name])
+				break
+			}
+		}
+	}
+	for _, n := range names {
+		if _, ok := BuiltinMaskRules[n]; !ok {
+			return nil, fmt.Errorf("不支持的内置脱敏规则 %s", n)
+		}
+	}
+	for _, r := range custom {
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("脱敏规则 %s 未配置pattern", r.Name)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// MaskScript 生成脱敏lua脚本
+func MaskScript(rules []MaskRule) string {
+	var b strings.Builder
+	for _, r := range rules {
+		// 使用lua长字符串避免转义pattern中的特殊字符
+		check := "nil"
+		if r.Check != "" {
+			check = fmt.Sprintf("%q", r.Check)
+		}
+		b.WriteString(fmt.Sprintf("    {pattern = [==[%s]==], replacement = [==[%s]==], check = %s},\n", r.Pattern, r.Replacement, check))
+	}
+	return fmt.Sprintf(maskScript, b.String(), MaskCall)
+}
+
+// NewMaskFilter 生成脱敏lua filter
+func NewMaskFilter(rules []MaskRule) Filter {
+	return Filter{
+		Name:   FilterLua,
+		Script: MaskScript(rules),
+		Call:   MaskCall,
+	}
+}
@@ -19,30 +19,46 @@ package fluent
 // Options 定义FluentBit配置结构体
 type Options struct {
 	ServiceLogLevel string `json:"serviceLogLevel,omitempty" yaml:"serviceLogLevel,omitempty" xml:"serviceLogLevel,omitempty" describe:"fluentBit日志level,默认info"`
+	ServiceGrace    int    `json:"serviceGrace,omitempty" yaml:"serviceGrace,omitempty" xml:"serviceGrace,omitempty" describe:"收到SIGTERM后刷新缓冲数据的最长时间(秒)"`
 	// Service             FluentBitService `json:"service,omitempty" xml:"service,omitempty" yaml:"service,omitempty" describe:"fluentBit日志level,默认info"`
 	// Input               FluentBitInput `json:"input,omitempty" xml:"input,omitempty" yaml:"input,omitempty" describe:"fluentBit INPUT"`
-	InputAppName         string `json:"inputAppName,omitempty" yaml:"inputAppName,omitempty" xml:"inputAppName,omitempty" describe:"采集日志的应用名称"`
-	InputLogPath         string `json:"inputLogPath,omitempty" yaml:"inputLogPath,omitempty" xml:"inputLogPath,omitempty" describe:"采集日志路劲"`
-	InputAppTag          string `json:"inputAppTag,omitempty" yaml:"inputAppTag,omitempty" xml:"inputAppTag,omitempty" describe:"采集日志的应用Tag"`
-	InputMemBufLimit     string `json:"inputMemBufLimit,omitempty" yaml:"inputMemBufLimit,omitempty" xml:"inputMemBufLimit,omitempty" describe:"采集日志的应用Tag"`
-	InputRefreshInterval int    `json:"inputRefreshInterval,omitempty" yaml:"inputRefreshInterval,omitempty" xml:"inputRefreshInterval,omitempty" describe:"采集日志刷新间隔"`
-	OutputEsHost         string `json:"outputEsHost,omitempty" yaml:"outputEsHost,omitempty" xml:"outputEsHost,omitempty" describe:"elasticsearch数据库地址"`
-	OutputEsPort         string `json:"outputEsPort,omitempty" yaml:"outputEsPort,omitempty" xml:"outputEsPort,omitempty" describe:"elasticsearch数据库端口"`
-	OutputEsIndex        string `json:"outputEsIndex,omitempty" yaml:"outputEsIndex,omitempty" xml:"outputEsIndex,omitempty" describe:"elasticsearch数据库index"`
-	OutputEsUser         string `json:"outputEsUser,omitempty" yaml:"outputEsUser,omitempty" xml:"outputEsUser,omitempty" describe:"elasticsearch数据库user"`
-	OutputEsPassword     string `json:"outputEsPassword,omitempty" yaml:"outputEsPassword,omitempty" xml:"outputEsPassword,omitempty" describe:"elasticsearch数据库password"`
-	OutputKafkaHost      string `json:"outputKafkaHost,omitempty" yaml:"outputKafkaHost,omitempty" xml:"outputKafkaHost,omitempty" describe:"kafka数据库地址"`
-	OutputKafkaPort      string `json:"outputKafkaPort,omitempty" yaml:"outputKafkaPort,omitempty" xml:"outputKafkaPort,omitempty" describe:"kafka数据库端口"`
-	OutputKafkaTopic     string `json:"outputKafkaTopic,omitempty" yaml:"outputKafkaTopic,omitempty" xml:"outputKafkaTopic,omitempty" describe:"kafka topic"`
-	OutputKafkaUser      string `json:"outputKafkaUser,omitempty" yaml:"outputKafkaUser,omitempty" xml:"outputKafkaUser,omitempty" describe:"kafka数据库user"`
-	OutputKafkaPassword  string `json:"outputKafkaPassword,omitempty" yaml:"outputKafkaPassword,omitempty" xml:"outputKafkaPassword,omitempty" describe:"kafka数据库password"`
+	InputAppName           string     `json:"inputAppName,omitempty" yaml:"inputAppName,omitempty" xml:"inputAppName,omitempty" describe:"采集日志的应用名称"`
+	InputLogPath           string     `json:"inputLogPath,omitempty" yaml:"inputLogPath,omitempty" xml:"inputLogPath,omitempty" describe:"采集日志路劲"`
+	InputAppTag            string     `json:"inputAppTag,omitempty" yaml:"inputAppTag,omitempty" xml:"inputAppTag,omitempty" describe:"采集日志的应用Tag"`
+	InputMemBufLimit       string     `json:"inputMemBufLimit,omitempty" yaml:"inputMemBufLimit,omitempty" xml:"inputMemBufLimit,omitempty" describe:"采集日志的应用Tag"`
+	InputRefreshInterval   int        `json:"inputRefreshInterval,omitempty" yaml:"inputRefreshInterval,omitempty" xml:"inputRefreshInterval,omitempty" describe:"采集日志刷新间隔"`
+	OutputEsHost           string     `json:"outputEsHost,omitempty" yaml:"outputEsHost,omitempty" xml:"outputEsHost,omitempty" describe:"elasticsearch数据库地址"`
+	OutputEsPort           string     `json:"outputEsPort,omitempty" yaml:"outputEsPort,omitempty" xml:"outputEsPort,omitempty" describe:"elasticsearch数据库端口"`
+	OutputEsIndex          string     `json:"outputEsIndex,omitempty" yaml:"outputEsIndex,omitempty" xml:"outputEsIndex,omitempty" describe:"elasticsearch数据库index"`
+	OutputEsUser           string     `json:"outputEsUser,omitempty" yaml:"outputEsUser,omitempty" xml:"outputEsUser,omitempty" describe:"elasticsearch数据库user"`
+	OutputEsPassword       string     `json:"outputEsPassword,omitempty" yaml:"outputEsPassword,omitempty" xml:"outputEsPassword,omitempty" describe:"elasticsearch数据库password"`
+	OutputKafkaHost        string     `json:"outputKafkaHost,omitempty" yaml:"outputKafkaHost,omitempty" xml:"outputKafkaHost,omitempty" describe:"kafka数据库地址"`
+	OutputKafkaPort        string     `json:"outputKafkaPort,omitempty" yaml:"outputKafkaPort,omitempty" xml:"outputKafkaPort,omitempty" describe:"kafka数据库端口"`
+	OutputKafkaTopic       string     `json:"outputKafkaTopic,omitempty" yaml:"outputKafkaTopic,omitempty" xml:"outputKafkaTopic,omitempty" describe:"kafka topic"`
+	OutputKafkaUser        string     `json:"outputKafkaUser,omitempty" yaml:"outputKafkaUser,omitempty" xml:"outputKafkaUser,omitempty" describe:"kafka数据库user"`
+	OutputKafkaPassword    string     `json:"outputKafkaPassword,omitempty" yaml:"outputKafkaPassword,omitempty" xml:"outputKafkaPassword,omitempty" describe:"kafka数据库password"`
+	StoragePath            string     `json:"storagePath,omitempty" yaml:"storagePath,omitempty" xml:"storagePath,omitempty" describe:"位置数据库与文件缓冲目录"`
+	StorageType            string     `json:"storageType,omitempty" yaml:"storageType,omitempty" xml:"storageType,omitempty" describe:"缓冲类型,memory或filesystem"`
+	StorageBacklogMemLimit string     `json:"storageBacklogMemLimit,omitempty" yaml:"storageBacklogMemLimit,omitempty" xml:"storageBacklogMemLimit,omitempty" describe:"积压数据加载到内存的上限"`
+	StorageMaxChunksUp     int        `json:"storageMaxChunksUp,omitempty" yaml:"storageMaxChunksUp,omitempty" xml:"storageMaxChunksUp,omitempty" describe:"内存中最多保留的chunk数量"`
+	StorageTotalLimitSize  string     `json:"storageTotalLimitSize,omitempty" yaml:"storageTotalLimitSize,omitempty" xml:"storageTotalLimitSize,omitempty" describe:"output文件缓冲总大小上限"`
+	Filters                []Filter   `json:"filters,omitempty" yaml:"filters,omitempty" xml:"filters,omitempty" describe:"FILTER处理流水线"`
+	MaskRules              []string   `json:"maskRules,omitempty" yaml:"maskRules,omitempty" xml:"maskRules,omitempty" describe:"启用的内置脱敏规则,email/bearer/creditcard/ip"`
+	MaskCustomRules        []MaskRule `json:"maskCustomRules,omitempty" yaml:"maskCustomRules,omitempty" xml:"maskCustomRules,omitempty" describe:"自定义脱敏规则"`
 }
 
 // NewFluentBitOptions 获取FluentBit配置方法
 func NewFluentBitOptions() *Options {
 	return &Options{
-		ServiceLogLevel:      "info",
-		InputMemBufLimit:     "20MB",
-		InputRefreshInterval: 20,
+		ServiceLogLevel:        "info",
+		ServiceGrace:           5,
+		InputLogPath:           "/var/log/app",
+		InputMemBufLimit:       "20MB",
+		InputRefreshInterval:   20,
+		StoragePath:            "/var/fluent-bit/state",
+		StorageType:            "memory",
+		StorageBacklogMemLimit: "5M",
+		StorageMaxChunksUp:     128,
+		MaskRules:              append([]string(nil), MaskRuleOrder...),
 	}
 }
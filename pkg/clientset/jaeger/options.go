@@ -16,9 +16,8 @@ limitations under the License.
 
 package jaeger
 
-// Options 链路跟踪相关、
+// Options 链路跟踪相关,tracing.exporter为jaeger时使用,jaeger exporter已废弃,建议使用OTLP
 type Options struct {
-	Enable bool   `json:"enable,omitempty" yaml:"enable,omitempty" xml:"enable,omitempty"`
 	Scheme string `yaml:"scheme,omitempty" xml:"scheme,omitempty" json:"scheme,omitempty"`
 	Host   string `json:"host,omitempty" yaml:"host,omitempty" xml:"host,omitempty"`
 	Port   string `json:"port,omitempty" xml:"port,omitempty" yaml:"port,omitempty"`
@@ -28,10 +27,10 @@ type Options struct {
 // NewJaegerOptions jaeger链路跟踪配置
 func NewJaegerOptions() *Options {
 	return &Options{
-		Enable: true,
 		Scheme: "http",
 		Host:   "127.0.0.1",
-		Port:   "16686",
-		Path:   "/api/traces",
+		// jaeger collector HTTP端口,16686为UI端口
+		Port: "14268",
+		Path: "/api/traces",
 	}
 }
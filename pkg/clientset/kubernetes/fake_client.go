@@ -53,6 +53,10 @@ func (n *FakeClient) Kubernetes() kubernetes.Interface {
 }
 
 func (n *FakeClient) Discovery() discovery.DiscoveryInterface {
+	// 未设置时返回nil接口,避免调用方判断nil失败
+	if n.DiscoveryClient == nil {
+		return nil
+	}
 	return n.DiscoveryClient
 }
 
@@ -29,6 +29,7 @@ import (
 type Client interface {
 	Kubernetes() kubernetes.Interface
 	Discovery() discovery.DiscoveryInterface
+	Prometheus() promresourcesclient.Interface
 	Master() string
 	Config() *rest.Config
 }
@@ -53,6 +54,7 @@ func NewKubernetesClientOrDie(options *KubernetesOptions) Client {
 
 	config.QPS = options.QPS
 	config.Burst = options.Burst
+	config.Wrap(instrument)
 	k := &kubernetesClient{
 		k8s:             kubernetes.NewForConfigOrDie(config),
 		discoveryClient: discovery.NewDiscoveryClientForConfigOrDie(config),
@@ -80,6 +82,7 @@ func NewKubernetesClient(options *KubernetesOptions) (Client, error) {
 	}
 	config.QPS = options.QPS
 	config.Burst = options.Burst
+	config.Wrap(instrument)
 	k.k8s, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
@@ -108,6 +111,11 @@ func (k *kubernetesClient) Discovery() discovery.DiscoveryInterface {
 	return k.discoveryClient
 }
 
+// Prometheus 实例化prometheus-operator客户端方法
+func (k *kubernetesClient) Prometheus() promresourcesclient.Interface {
+	return k.prometheus
+}
+
 // Master 实例化Master()方法
 func (k *kubernetesClient) Master() string {
 	return k.master
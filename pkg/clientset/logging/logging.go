@@ -30,8 +30,28 @@ const (
 	TimeFormat = "2006-01-02 15:04:05.000"
 )
 
-// Logger 定义全局logger变量
-var Logger *zap.Logger
+// 日志格式
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// Logger 定义全局logger变量,调用Options.Logger()初始化之前不输出日志
+var Logger = zap.NewNop()
+
+// Level 全局日志级别,Options.Logger()按配置初始化,运行中可通过SetLevel修改,
+// 同时实现了http.Handler,GET查询、PUT {"level":"debug"}修改
+var Level = zap.NewAtomicLevel()
+
+// SetLevel 运行中修改全局日志级别
+func SetLevel(level string) error {
+	l, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Level.SetLevel(l)
+	return nil
+}
 
 type Logging interface {
 	Encoder() zapcore.Encoder
@@ -54,27 +74,34 @@ func (o *Options) Logger() *zap.Logger {
 	)
 	Encoder := o.Encoder()
 	WriteSyncer := o.WriteSyncer()
-	LevelEnabler := o.LevelEnabler()
+	Level.SetLevel(o.LevelEnabler())
 	ConsoleEncoder := o.ConsoleEncoder()
 	switch o.WriteLog {
 	case true:
 		newCore = zapcore.NewTee(
-			zapcore.NewCore(Encoder, WriteSyncer, LevelEnabler),                          // 写入文件
-			zapcore.NewCore(ConsoleEncoder, zapcore.Lock(os.Stdout), zapcore.DebugLevel), // 写入控制台
+			zapcore.NewCore(Encoder, WriteSyncer, Level),                    // 写入文件
+			zapcore.NewCore(ConsoleEncoder, zapcore.Lock(os.Stdout), Level), // 写入控制台
 		)
 	default:
 		// 默认日志不写入文件中
 		newCore = zapcore.NewTee(
-			zapcore.NewCore(ConsoleEncoder, zapcore.Lock(os.Stdout), zapcore.DebugLevel), // 写入控制台
+			zapcore.NewCore(ConsoleEncoder, zapcore.Lock(os.Stdout), Level), // 写入控制台
 		)
 	}
+	// 相同级别与内容的日志每秒超过SamplingInitial条后按SamplingThereafter采样,避免大量重复事件刷屏
+	if o.SamplingInitial > 0 {
+		newCore = zapcore.NewSamplerWithOptions(newCore, time.Second, o.SamplingInitial, o.SamplingThereafter)
+	}
 	Logger = zap.New(newCore, zap.AddCaller())
 	zap.ReplaceGlobals(Logger)
 	return Logger
 }
 
-// Encoder 自定义的Encoder
+// Encoder 自定义的Encoder,json格式时输出每行一个JSON对象
 func (o *Options) Encoder() zapcore.Encoder {
+	if o.Format == FormatJSON {
+		return o.JSONEncoder()
+	}
 	return zapcore.NewConsoleEncoder(
 		zapcore.EncoderConfig{
 			TimeKey:        "ts",
@@ -94,9 +121,21 @@ func (o *Options) Encoder() zapcore.Encoder {
 
 // ConsoleEncoder 输出日志到控制台
 func (o *Options) ConsoleEncoder() zapcore.Encoder {
+	if o.Format == FormatJSON {
+		return o.JSONEncoder()
+	}
 	return zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
 }
 
+// JSONEncoder 输出JSON格式日志,供日志采集系统按字段检索
+func (o *Options) JSONEncoder() zapcore.Encoder {
+	config := zap.NewProductionEncoderConfig()
+	config.TimeKey = "ts"
+	config.CallerKey = "caller_line"
+	config.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zapcore.NewJSONEncoder(config)
+}
+
 // WriteSyncer 自定义的WriteSyncer
 func (o *Options) WriteSyncer() zapcore.WriteSyncer {
 	lumberJackLogger := &lumberjack.Logger{
@@ -108,9 +147,13 @@ func (o *Options) WriteSyncer() zapcore.WriteSyncer {
 	return zapcore.AddSync(lumberJackLogger)
 }
 
-// LevelEnabler 自定义的LevelEnabler
+// LevelEnabler 配置的日志级别,未配置或无效时为info
 func (o *Options) LevelEnabler() zapcore.Level {
-	return zapcore.InfoLevel
+	level, err := zapcore.ParseLevel(o.Level)
+	if err != nil {
+		return zapcore.InfoLevel
+	}
+	return level
 }
 
 // EncodeLevel 自定义日志级别显示
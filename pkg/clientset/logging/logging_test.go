@@ -0,0 +1,60 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"testing"
+)
+
+func TestJSONEncoderWithContextFields(t *testing.T) {
+	options := NewLoggingOptions()
+	options.Format = FormatJSON
+	var buf bytes.Buffer
+	Level.SetLevel(options.LevelEnabler())
+	logger := zap.New(zapcore.NewCore(options.Encoder(), zapcore.AddSync(&buf), Level))
+	ctx := WithLogger(context.Background(), logger.With(zap.String("namespace", "default"), zap.String("workload", "app")))
+	FromContext(ctx).Debug("丢弃")
+	FromContext(ctx).Info("注入sidecar成功")
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("output %q is not a single JSON line: %v", buf.String(), err)
+	}
+	if entry["namespace"] != "default" || entry["workload"] != "app" || entry["level"] != "info" {
+		t.Errorf("entry = %v", entry)
+	}
+	// 运行中调低日志级别后输出debug日志
+	if err := SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	defer Level.SetLevel(zapcore.InfoLevel)
+	buf.Reset()
+	FromContext(ctx).Debug("debug")
+	if buf.Len() == 0 {
+		t.Error("debug log not written after SetLevel")
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if FromContext(context.Background()) != Logger {
+		t.Error("expected global Logger without context logger")
+	}
+}
@@ -19,11 +19,16 @@ package logging
 // Options 日志日志结构体
 type Options struct {
 	LogPath    string `json:"logPath,omitempty" yaml:"logPath,omitempty" xml:"logPath,omitempty" description:"日志写入路径" example:"/tmp"`
-	WriteLog   bool   `json:"writeLog,omitempty" yaml:"writeLog,omitempty" xml:"writeLog,omitempty"description:"是否将日志写入文件中"`
+	WriteLog   bool   `json:"writeLog,omitempty" yaml:"writeLog,omitempty" xml:"writeLog,omitempty" description:"是否将日志写入文件中"`
 	MaxSize    int    `json:"maxSize,omitempty" yaml:"maxSize,omitempty" xml:"maxSize,omitempty" description:"日志文件最大大小，单位MB"`
 	MaxBackups int    `json:"maxBackups,omitempty" yaml:"maxBackups,omitempty" xml:"maxBackups,omitempty" description:"日志文件保留数量"`
 	MaxAge     int    `json:"maxAge,omitempty" yaml:"maxAge,omitempty" xml:"maxAge,omitempty" description:"日志文件保留天数"`
 	Filename   string `json:"filename,omitempty" yaml:"filename,omitempty" xml:"filename,omitempty" description:"日志文件名称"`
+	Level      string `json:"level,omitempty" yaml:"level,omitempty" xml:"level,omitempty" description:"日志级别,debug/info/warn/error,运行中可通过配置文件或/debug/loglevel修改" example:"info"`
+	Format     string `json:"format,omitempty" yaml:"format,omitempty" xml:"format,omitempty" description:"日志格式,console或json" example:"console"`
+	// 每秒相同级别与内容的日志输出SamplingInitial条后,每SamplingThereafter条输出1条,SamplingInitial为0时不采样
+	SamplingInitial    int `json:"samplingInitial,omitempty" yaml:"samplingInitial,omitempty" xml:"samplingInitial,omitempty" description:"每秒相同日志完整输出的条数"`
+	SamplingThereafter int `json:"samplingThereafter,omitempty" yaml:"samplingThereafter,omitempty" xml:"samplingThereafter,omitempty" description:"超出后每多少条输出1条"`
 }
 
 // NewLoggingOptions 日志配置
@@ -35,5 +40,10 @@ func NewLoggingOptions() *Options {
 		MaxSize:    10,
 		MaxBackups: 10,
 		MaxAge:     10,
+		Level:      "info",
+		Format:     FormatConsole,
+		// 与zap生产环境默认值一致
+		SamplingInitial:    100,
+		SamplingThereafter: 100,
 	}
 }
@@ -0,0 +1,56 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+// Options 定义prometheus-operator监控资源配置
+type Options struct {
+	// PodMonitor 是否为注入sidecar的工作负载创建PodMonitor,集群需要安装prometheus-operator
+	PodMonitor bool `json:"podMonitor,omitempty" xml:"podMonitor,omitempty" yaml:"podMonitor,omitempty"`
+	// Interval 采集间隔,为空则使用prometheus全局配置
+	Interval string `json:"interval,omitempty" xml:"interval,omitempty" yaml:"interval,omitempty"`
+	// Labels PodMonitor附加标签,用于匹配Prometheus的podMonitorSelector
+	Labels map[string]string `json:"labels,omitempty" xml:"labels,omitempty" yaml:"labels,omitempty"`
+	// PrometheusRule 是否创建sidecar日志投递告警规则
+	PrometheusRule bool `json:"prometheusRule,omitempty" xml:"prometheusRule,omitempty" yaml:"prometheusRule,omitempty"`
+	// RuleNamespace PrometheusRule所在namespace
+	RuleNamespace string `json:"ruleNamespace,omitempty" xml:"ruleNamespace,omitempty" yaml:"ruleNamespace,omitempty"`
+	// RuleWindow 计算指标增量的时间窗口,RuleFor 告警持续时间
+	RuleWindow string `json:"ruleWindow,omitempty" xml:"ruleWindow,omitempty" yaml:"ruleWindow,omitempty"`
+	RuleFor    string `json:"ruleFor,omitempty" xml:"ruleFor,omitempty" yaml:"ruleFor,omitempty"`
+	// Severity 告警级别标签
+	Severity string `json:"severity,omitempty" xml:"severity,omitempty" yaml:"severity,omitempty"`
+	// 时间窗口内指标增量超过阈值时告警
+	RetriesThreshold        float64 `json:"retriesThreshold,omitempty" xml:"retriesThreshold,omitempty" yaml:"retriesThreshold,omitempty"`
+	RetriesFailedThreshold  float64 `json:"retriesFailedThreshold,omitempty" xml:"retriesFailedThreshold,omitempty" yaml:"retriesFailedThreshold,omitempty"`
+	ErrorsThreshold         float64 `json:"errorsThreshold,omitempty" xml:"errorsThreshold,omitempty" yaml:"errorsThreshold,omitempty"`
+	DroppedRecordsThreshold float64 `json:"droppedRecordsThreshold,omitempty" xml:"droppedRecordsThreshold,omitempty" yaml:"droppedRecordsThreshold,omitempty"`
+	RestartsThreshold       float64 `json:"restartsThreshold,omitempty" xml:"restartsThreshold,omitempty" yaml:"restartsThreshold,omitempty"`
+	// NamespaceRoutes namespace与附加到该namespace告警的标签,用于alertmanager按团队路由
+	NamespaceRoutes map[string]map[string]string `json:"namespaceRoutes,omitempty" xml:"namespaceRoutes,omitempty" yaml:"namespaceRoutes,omitempty"`
+}
+
+func NewMonitoringOptions() *Options {
+	return &Options{
+		Interval:          "30s",
+		RuleNamespace:     "kube-sidecar",
+		RuleWindow:        "5m",
+		RuleFor:           "5m",
+		Severity:          "warning",
+		RetriesThreshold:  10,
+		RestartsThreshold: 2,
+	}
+}
@@ -25,21 +25,78 @@ type Options struct {
 	RequestsMemory  string `json:"requestsMemory,omitempty" yaml:"requestsMemory,omitempty" xml:"requestsMemory,omitempty"`
 	LimitCPU        string `json:"limitCPU,omitempty" yaml:"limitCPU,omitempty" xml:"limitCPU,omitempty"`
 	LimitMemory     string `json:"limitMemory,omitempty" yaml:"limitMemory,omitempty" xml:"limitMemory,omitempty"`
-	VolumeName      string `json:"volumeName,omitempty" yaml:"volumeName,omitempty" xml:"volumeName,omitempty"`
-	VolumeMount     string `json:"volumeMount,omitempty" yaml:"volumeMount,omitempty" xml:"volumeMount,omitempty"`
-	ReadOnly        bool   `json:"readOnly,omitempty" yaml:"readOnly,omitempty" xml:"readOnly,omitempty"`
+	// MinCPU、MaxCPU、MinMemory、MaxMemory 工作负载注释覆盖资源配置时允许的范围,为空则不限制
+	MinCPU      string `json:"minCPU,omitempty" yaml:"minCPU,omitempty" xml:"minCPU,omitempty"`
+	MaxCPU      string `json:"maxCPU,omitempty" yaml:"maxCPU,omitempty" xml:"maxCPU,omitempty"`
+	MinMemory   string `json:"minMemory,omitempty" yaml:"minMemory,omitempty" xml:"minMemory,omitempty"`
+	MaxMemory   string `json:"maxMemory,omitempty" yaml:"maxMemory,omitempty" xml:"maxMemory,omitempty"`
+	VolumeName  string `json:"volumeName,omitempty" yaml:"volumeName,omitempty" xml:"volumeName,omitempty"`
+	VolumeMount string `json:"volumeMount,omitempty" yaml:"volumeMount,omitempty" xml:"volumeMount,omitempty"`
+	ReadOnly    bool   `json:"readOnly,omitempty" yaml:"readOnly,omitempty" xml:"readOnly,omitempty"`
+	// LogVolumeName 应用容器与sidecar容器共享的日志卷名称
+	LogVolumeName string `json:"logVolumeName,omitempty" yaml:"logVolumeName,omitempty" xml:"logVolumeName,omitempty"`
+	// LogVolumeSizeLimit 共享日志卷emptyDir的大小限制,为空则不限制
+	LogVolumeSizeLimit string `json:"logVolumeSizeLimit,omitempty" yaml:"logVolumeSizeLimit,omitempty" xml:"logVolumeSizeLimit,omitempty"`
+	// StorageVolumeName fluentBit位置数据库与文件缓冲卷名称
+	StorageVolumeName string `json:"storageVolumeName,omitempty" yaml:"storageVolumeName,omitempty" xml:"storageVolumeName,omitempty"`
+	// StorageVolumeSizeLimit fluentBit位置数据库与文件缓冲卷大小限制,为空则不限制
+	StorageVolumeSizeLimit string `json:"storageVolumeSizeLimit,omitempty" yaml:"storageVolumeSizeLimit,omitempty" xml:"storageVolumeSizeLimit,omitempty"`
+	// NativeSidecar 是否以restartPolicy Always的初始化容器注入sidecar,auto时通过discovery检测kube-apiserver版本,
+	// 默认关闭,所有节点的kubelet都支持SidecarContainers时才能开启
+	NativeSidecar string `json:"nativeSidecar,omitempty" yaml:"nativeSidecar,omitempty" xml:"nativeSidecar,omitempty"`
+	// CompletionImage 完成模式下sidecar使用的镜像,需要包含/bin/sh,为空时使用与sidecar镜像版本相同的fluent-bit debug镜像
+	CompletionImage string `json:"completionImage,omitempty" yaml:"completionImage,omitempty" xml:"completionImage,omitempty"`
+	// CompletionVolumeName、CompletionPath 完成模式下应用容器与sidecar共享的哨兵文件卷名称与挂载目录
+	CompletionVolumeName string `json:"completionVolumeName,omitempty" yaml:"completionVolumeName,omitempty" xml:"completionVolumeName,omitempty"`
+	CompletionPath       string `json:"completionPath,omitempty" yaml:"completionPath,omitempty" xml:"completionPath,omitempty"`
+	// RunAsNonRoot 禁止sidecar容器以root用户运行
+	RunAsNonRoot bool `json:"runAsNonRoot,omitempty" yaml:"runAsNonRoot,omitempty" xml:"runAsNonRoot,omitempty"`
+	// RunAsUser sidecar容器运行用户,为0则使用镜像默认用户
+	RunAsUser int64 `json:"runAsUser,omitempty" yaml:"runAsUser,omitempty" xml:"runAsUser,omitempty"`
+	// DropCapabilities sidecar容器移除的Linux capabilities
+	DropCapabilities []string `json:"dropCapabilities,omitempty" yaml:"dropCapabilities,omitempty" xml:"dropCapabilities,omitempty"`
+	// SeccompProfile seccomp配置类型,为空则不设置
+	SeccompProfile string `json:"seccompProfile,omitempty" yaml:"seccompProfile,omitempty" xml:"seccompProfile,omitempty"`
+	// Probes 是否通过fluentBit HTTP服务设置存活与就绪探针
+	Probes bool `json:"probes,omitempty" yaml:"probes,omitempty" xml:"probes,omitempty"`
+	// ProbePeriodSeconds 探针检查间隔
+	ProbePeriodSeconds int32 `json:"probePeriodSeconds,omitempty" yaml:"probePeriodSeconds,omitempty" xml:"probePeriodSeconds,omitempty"`
+	// ProbeFailureThreshold 探针连续失败次数阈值
+	ProbeFailureThreshold int32 `json:"probeFailureThreshold,omitempty" yaml:"probeFailureThreshold,omitempty" xml:"probeFailureThreshold,omitempty"`
+	// PreStopCommand sidecar容器停止前执行的命令,默认镜像不包含shell,需要配合debug镜像使用
+	PreStopCommand []string `json:"preStopCommand,omitempty" yaml:"preStopCommand,omitempty" xml:"preStopCommand,omitempty"`
+	// TerminationGracePeriodSeconds pod优雅停止的最短时间,需大于preStop耗时与fluentBit Grace之和
+	TerminationGracePeriodSeconds int64 `json:"terminationGracePeriodSeconds,omitempty" yaml:"terminationGracePeriodSeconds,omitempty" xml:"terminationGracePeriodSeconds,omitempty"`
 }
 
 // NewSidecarOptions 容器配置
 func NewSidecarOptions() *Options {
 	return &Options{
-		Name:            "sidecar",
-		Image:           "fluent/fluent-bit:2.1.0",
-		ImagePullPolicy: "IfNotPresent",
-		RequestsCPU:     "250m",
-		RequestsMemory:  "512Mi",
-		LimitCPU:        "250m",
-		LimitMemory:     "512Mi",
-		ReadOnly:        true,
+		Name:              "sidecar",
+		Image:             "fluent/fluent-bit:2.1.0",
+		ImagePullPolicy:   "IfNotPresent",
+		RequestsCPU:       "250m",
+		RequestsMemory:    "512Mi",
+		LimitCPU:          "250m",
+		LimitMemory:       "512Mi",
+		ReadOnly:          true,
+		VolumeName:        "sidecar-config",
+		VolumeMount:       "/fluent-bit/etc/kube-sidecar",
+		LogVolumeName:     "sidecar-logs",
+		StorageVolumeName: "sidecar-storage",
+		NativeSidecar:     "false",
+		// 完成模式
+		CompletionImage:      "",
+		CompletionVolumeName: "sidecar-completion",
+		CompletionPath:       "/kube-sidecar/completion",
+		RunAsNonRoot:         true,
+		RunAsUser:            65534,
+		DropCapabilities:     []string{"ALL"},
+		SeccompProfile:       "RuntimeDefault",
+		Probes:               true,
+		// 与kubernetes默认值保持一致
+		ProbePeriodSeconds:            10,
+		ProbeFailureThreshold:         3,
+		TerminationGracePeriodSeconds: 30,
 	}
 }
@@ -0,0 +1,66 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+// 链路跟踪导出方式
+const (
+	ExporterOTLPGRPC = "otlp-grpc"
+	ExporterOTLPHTTP = "otlp-http"
+	// ExporterJaeger 使用jaegerConfig配置的collector,jaeger exporter已废弃,仅用于兼容
+	ExporterJaeger = "jaeger"
+	ExporterNone   = "none"
+)
+
+// Options 定义链路跟踪配置
+type Options struct {
+	// Exporter 导出方式,可选otlp-grpc、otlp-http、jaeger、none
+	Exporter string `json:"exporter,omitempty" yaml:"exporter,omitempty" xml:"exporter,omitempty"`
+	// Endpoint OTLP接收端地址,格式为host:port
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty" xml:"endpoint,omitempty"`
+	// URLPath otlp-http的请求路径,为空则使用/v1/traces
+	URLPath string `json:"urlPath,omitempty" yaml:"urlPath,omitempty" xml:"urlPath,omitempty"`
+	// Headers 导出请求附加的header,如认证token
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty" xml:"headers,omitempty"`
+	// Insecure 使用明文连接,为false时使用TLS
+	Insecure bool `json:"insecure,omitempty" yaml:"insecure,omitempty" xml:"insecure,omitempty"`
+	// TLS 配置,CAFile为空时使用系统根证书,CertFile与KeyFile用于双向认证
+	CAFile             string `json:"caFile,omitempty" yaml:"caFile,omitempty" xml:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty" yaml:"certFile,omitempty" xml:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty" yaml:"keyFile,omitempty" xml:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty" xml:"insecureSkipVerify,omitempty"`
+	// SamplingRatio 采样比例,取值0到1,父span已采样时跟随父span
+	SamplingRatio float64 `json:"samplingRatio,omitempty" yaml:"samplingRatio,omitempty" xml:"samplingRatio,omitempty"`
+	// ResourceAttributes 附加到所有span的资源属性,如k8s.cluster.name;
+	// 属性名通常包含".",viper会将map中带"."的key解析为嵌套结构,因此使用key、value列表
+	ResourceAttributes []Attribute `json:"resourceAttributes,omitempty" yaml:"resourceAttributes,omitempty" xml:"resourceAttributes,omitempty"`
+}
+
+// Attribute 资源属性
+type Attribute struct {
+	Key   string `json:"key,omitempty" yaml:"key,omitempty" xml:"key,omitempty"`
+	Value string `json:"value,omitempty" yaml:"value,omitempty" xml:"value,omitempty"`
+}
+
+// NewTracingOptions 链路跟踪配置,默认不导出,配置OTLP接收端后开启
+func NewTracingOptions() *Options {
+	return &Options{
+		Exporter:      ExporterNone,
+		Endpoint:      "localhost:4317",
+		Insecure:      true,
+		SamplingRatio: 1,
+	}
+}
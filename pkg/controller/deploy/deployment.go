@@ -18,85 +18,326 @@ package deploy
 
 import (
 	"context"
-	"go.opentelemetry.io/otel"
+	"fmt"
 	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"kube-sidecar/pkg/clientset/controller"
 	"kube-sidecar/pkg/clientset/fluent"
 	"kube-sidecar/pkg/clientset/jaeger"
 	"kube-sidecar/pkg/clientset/kubernetes"
 	lg "kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/clientset/monitoring"
 	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/clientset/tracing"
 	"kube-sidecar/pkg/clientset/workload"
+	"kube-sidecar/pkg/health"
+	"kube-sidecar/pkg/metrics"
+	"kube-sidecar/pkg/model/container"
 	"kube-sidecar/pkg/model/deploy"
-	"kube-sidecar/utils/tools"
+	"kube-sidecar/pkg/model/event"
+	"kube-sidecar/pkg/model/monitor"
+	"kube-sidecar/pkg/model/secret"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
-// 定义全局annotation key值
-const (
-	annotationKey = "deployment.kubernetes.io/sidecar"
-)
+// maxRetries deployment处理失败后的最大重试次数
+const maxRetries = 5
 
 type deployment struct {
-	K8sClient kubernetes.Client
-	FluentBit fluent.Options
-	Sidecar   sidecar.Options
-	Jeager    jaeger.Options
-	WhiteList workload.Options
+	K8sClient  kubernetes.Client
+	FluentBit  fluent.Options
+	Sidecar    sidecar.Options
+	Jeager     jaeger.Options
+	WhiteList  workload.Options
+	Controller controller.Options
+	Monitoring monitoring.Options
+	// watching deployment的watch是否已建立,用于就绪检查
+	watching atomic.Bool
+	// excluded 已记录ExcludedByPolicy事件的工作负载
+	excluded map[string]bool
+	// dryRun dry-run模式下已报告的工作负载及报告时的generation与注释hash
+	dryRun map[string]string
+	// monitored 已创建PodMonitor的工作负载,移除sidecar后据此清理PodMonitor
+	monitored map[string]bool
 }
 
 type Deployment interface {
-	Watch(ctx context.Context, tracerName, spanName string)
+	Watch(ctx context.Context)
 }
 
-func NewDeployment(k8sClient kubernetes.Client, fluentBit fluent.Options, sidecar sidecar.Options, jeager jaeger.Options, whiteList workload.Options) Deployment {
+func NewDeployment(k8sClient kubernetes.Client, fluentBit fluent.Options, sidecar sidecar.Options, jeager jaeger.Options, whiteList workload.Options, controller controller.Options, monitoring monitoring.Options) Deployment {
 	return &deployment{
-		K8sClient: k8sClient,
-		FluentBit: fluentBit,
-		Sidecar:   sidecar,
-		Jeager:    jeager,
-		WhiteList: whiteList,
+		K8sClient:  k8sClient,
+		FluentBit:  fluentBit,
+		Sidecar:    sidecar,
+		Jeager:     jeager,
+		WhiteList:  whiteList,
+		Controller: controller,
+		Monitoring: monitoring,
+		excluded:   make(map[string]bool),
+		dryRun:     make(map[string]string),
+		monitored:  make(map[string]bool),
 	}
 }
 
 // Watch watching kubernetes deployment changes
-func (d *deployment) Watch(ctx context.Context, tracerName, spanName string) {
-	// 增加链路跟踪
-	if d.Jeager.Enable {
-		tr := otel.Tracer(tracerName)
-		_, span := tr.Start(ctx, spanName)
-		span.SetAttributes(attribute.Key("update").String("deployment"))
-		lg.Logger.Info("添加trace链路跟踪成功")
-		defer span.End()
+func (d *deployment) Watch(ctx context.Context) {
+	// 检测集群是否支持原生sidecar
+	d.Sidecar.NativeSidecar = container.ResolveNative(d.Sidecar.NativeSidecar, d.K8sClient)
+	lg.Logger.Info("原生sidecar模式", zap.String("nativeSidecar", d.Sidecar.NativeSidecar))
+	// 创建sidecar日志投递告警规则
+	if d.Monitoring.PrometheusRule {
+		ruleCtx, span := tracing.Start(ctx, "prometheusrule.apply")
+		err := monitor.NewPrometheusRule(d.K8sClient, d.Controller).Apply(ruleCtx, monitor.BuildRule(d.Sidecar.Name, d.Monitoring))
+		tracing.End(span, err)
+		if err != nil {
+			lg.Logger.Error("创建PrometheusRule失败", zap.Error(err))
+		}
+	}
+	// watch建立前控制器不就绪
+	health.AddReadyCheck("deployment-watch", func() error {
+		if !d.watching.Load() {
+			return fmt.Errorf("Deployment的watch未建立")
+		}
+		return nil
+	})
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "deployment")
+	defer queue.ShutDown()
+	go wait.Until(func() {
+		for d.processNextItem(queue) {
+		}
+	}, time.Second, ctx.Done())
+	// watch断开后重新建立,重新建立时会收到全部deployment的Added事件
+	for ctx.Err() == nil {
+		d.watch(ctx, queue)
+		if ctx.Err() == nil {
+			metrics.WatchRestarts.Inc()
+			lg.Logger.Warn("Deployment的watch已断开,重新建立")
+			time.Sleep(time.Second)
+		}
 	}
+}
+
+// watch 监听deployment变化,将变化的deployment加入工作队列,watch断开时返回
+func (d *deployment) watch(ctx context.Context, queue workqueue.RateLimitingInterface) {
 	// 创建watchInterface接口
-	watchInterface, err := d.K8sClient.Kubernetes().AppsV1().Deployments("").Watch(context.TODO(), metav1.ListOptions{})
+	watchInterface, err := d.K8sClient.Kubernetes().AppsV1().Deployments("").Watch(ctx, metav1.ListOptions{})
 	if err != nil {
-		lg.Logger.Error("创建Deployment的watch失败,错误信息" + err.Error())
+		lg.Logger.Error("创建Deployment的watch失败", zap.Error(err))
+		return
 	}
+	defer watchInterface.Stop()
+	d.watching.Store(true)
+	defer d.watching.Store(false)
 	// 开始执行watching deployment
 	for event := range watchInterface.ResultChan() {
-		// 检查操作事件是否为修改或者新增
-		if event.Type == watch.Modified || event.Type == watch.Added {
-			// 获取更新的Deployment 对象
-			dp := event.Object.(*appsv1.Deployment)
-			// 检查deployment是否有required annotation
-			annotations := dp.GetAnnotations()
-			if annotations[annotationKey] == "true" &&
-				// 判断该工作负载是否已经在namespace白名单中
-				tools.WhetherExists(dp.Namespace, d.WhiteList.Namespaces) == false &&
-				// 判断该工作负载是否已经在deployment白名单中
-				tools.WhetherExists(dp.Name, d.WhiteList.Deployments) == false &&
-				// 判断该deployment是否已经注入sidecar容器
-				tools.WhetherExists(d.Sidecar.Name, tools.WorkloadContainerNames("Deployment", event.Object)) == false {
-				// 执行自动添加sidecar容器
-				err = deploy.NewDeploy(d.K8sClient, d.FluentBit).AddSidecar(dp)
-				if err != nil {
-					lg.Logger.Error(dp.Name + " 自动添加sidecar容器镜像失败,错误信息," + err.Error())
-				}
-			}
+		if event.Type != watch.Added && event.Type != watch.Modified && event.Type != watch.Deleted {
 			continue
 		}
+		if dp, ok := event.Object.(*appsv1.Deployment); ok {
+			queue.Add(dp.Namespace + "/" + dp.Name)
+		}
+	}
+}
+
+// processNextItem 处理工作队列中的deployment,失败时按速率限制重试,队列关闭时返回false
+func (d *deployment) processNextItem(queue workqueue.RateLimitingInterface) bool {
+	key, quit := queue.Get()
+	if quit {
+		return false
+	}
+	defer queue.Done(key)
+	err := d.reconcile(key.(string))
+	if err == nil {
+		queue.Forget(key)
+		return true
+	}
+	if queue.NumRequeues(key) < maxRetries {
+		queue.AddRateLimited(key)
+		return true
+	}
+	queue.Forget(key)
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key.(string))
+	lg.Logger.Error("重试后仍然失败,放弃处理",
+		zap.String("namespace", namespace), zap.String("workload", name), zap.Int("retries", maxRetries), zap.Error(err))
+	return true
+}
+
+// reconcile 获取deployment的最新状态,需要时注入sidecar并维护PodMonitor,deployment已删除时清理kube-sidecar创建的资源,
+// 每次处理生成一条trace,日志带有namespace、workload、reconcileID与traceID字段
+func (d *deployment) reconcile(key string) (err error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
+	}
+	ctx, span := tracing.Start(context.Background(), "reconcile",
+		attribute.String("namespace", namespace),
+		attribute.String("name", name))
+	defer func() { tracing.End(span, err) }()
+	fields := []zap.Field{
+		zap.String("namespace", namespace),
+		zap.String("workload", name),
+		zap.String("reconcileID", string(uuid.NewUUID())),
+	}
+	if span.SpanContext().HasTraceID() {
+		fields = append(fields, zap.String("traceID", span.SpanContext().TraceID().String()))
+	}
+	ctx = lg.WithLogger(ctx, lg.Logger.With(fields...))
+	dp, err := d.K8sClient.Kubernetes().AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		span.SetAttributes(attribute.String("result", metrics.ResultDeleted))
+		d.cleanup(ctx, namespace, name)
+		delete(d.excluded, key)
+		delete(d.dryRun, key)
+		delete(d.monitored, key)
+		metrics.Reconciliations.WithLabelValues(metrics.ResultDeleted).Inc()
+		return nil
+	}
+	if err != nil {
+		metrics.Reconciliations.WithLabelValues(metrics.ResultError).Inc()
+		return err
+	}
+	// 检查deployment是否有required annotation、是否在白名单中以及是否已经注入sidecar容器
+	_, policySpan := tracing.Start(ctx, "policy")
+	reason := deploy.ExcludedReason(dp.ObjectMeta, dp.Spec.Template.Spec, d.Sidecar.Name, d.WhiteList)
+	policySpan.SetAttributes(attribute.String("reason", reason))
+	policySpan.End()
+	d.excludedByPolicy(ctx, dp, reason)
+	switch reason {
+	case "":
+		if d.Controller.DryRun {
+			var result string
+			result, err = d.reportDryRun(ctx, dp)
+			metrics.Reconciliations.WithLabelValues(result).Inc()
+			span.SetAttributes(attribute.String("result", result))
+			return err
+		}
+		// 执行自动添加sidecar容器
+		start := time.Now()
+		err = deploy.NewDeploy(d.K8sClient, d.FluentBit, d.Sidecar, d.Controller).AddSidecar(ctx, dp)
+		if err != nil {
+			metrics.Reconciliations.WithLabelValues(metrics.ResultError).Inc()
+			return err
+		}
+		metrics.InjectionDuration.Observe(time.Since(start).Seconds())
+		metrics.Reconciliations.WithLabelValues(metrics.ResultInjected).Inc()
+		span.SetAttributes(attribute.String("result", metrics.ResultInjected))
+		d.applyPodMonitor(ctx, dp)
+	case deploy.ReasonAlreadyInjected:
+		metrics.Reconciliations.WithLabelValues(metrics.ResultSkipped).Inc()
+		d.applyPodMonitor(ctx, dp)
+	default:
+		metrics.Reconciliations.WithLabelValues(metrics.ResultSkipped).Inc()
+		// 只有移除了sidecar的工作负载需要清理PodMonitor,未开启注入的工作负载不查询PodMonitor
+		if !deploy.Injected(dp.Spec.Template.Spec, d.Sidecar.Name) && (d.monitored[key] || hasInjectionMarker(dp)) {
+			d.deletePodMonitor(ctx, dp.Namespace, dp.Name)
+			delete(d.monitored, key)
+		}
+	}
+	return nil
+}
+
+// reportDryRun dry-run模式下报告将要执行的注入,generation与注释未变化时不重复报告,
+// 避免状态更新与watch重新建立触发的事件重复记录DryRun事件;不创建PodMonitor,返回本次处理的结果标签
+func (d *deployment) reportDryRun(ctx context.Context, dp *appsv1.Deployment) (string, error) {
+	key := dp.Namespace + "/" + dp.Name
+	reported := dryRunKey(dp)
+	if d.dryRun[key] == reported {
+		return metrics.ResultSkipped, nil
+	}
+	err := deploy.NewDeploy(d.K8sClient, d.FluentBit, d.Sidecar, d.Controller).AddSidecar(ctx, dp)
+	if err != nil {
+		return metrics.ResultError, err
+	}
+	d.dryRun[key] = reported
+	return metrics.ResultDryRun, nil
+}
+
+// dryRunKey 工作负载的generation与注释hash,pod模版或注入配置变化时改变
+func dryRunKey(dp *appsv1.Deployment) string {
+	annotations := make(map[string][]byte, len(dp.Annotations))
+	for k, v := range dp.Annotations {
+		annotations[k] = []byte(v)
+	}
+	return strconv.FormatInt(dp.Generation, 10) + "/" + secret.Hash(annotations)
+}
+
+// hasInjectionMarker 工作负载是否带有注入时记录的标记或状态注释,控制器重启后据此判断是否曾经注入
+func hasInjectionMarker(dp *appsv1.Deployment) bool {
+	return dp.Annotations[deploy.AnnotationInjectedContainer] != "" ||
+		dp.Annotations[deploy.AnnotationStatus] == deploy.StatusInjected
+}
+
+// excludedByPolicy 开启了注入但被白名单排除时在deployment上记录事件,每个deployment只记录一次
+func (d *deployment) excludedByPolicy(ctx context.Context, dp *appsv1.Deployment, reason string) {
+	key := dp.Namespace + "/" + dp.Name
+	if reason != deploy.ReasonNamespaceWhiteList && reason != deploy.ReasonWorkloadWhiteList {
+		delete(d.excluded, key)
+		return
+	}
+	if d.excluded[key] {
+		return
+	}
+	d.excluded[key] = true
+	message := "deployment开启了sidecar注入,但被白名单排除: " + reason
+	lg.FromContext(ctx).Info("deployment开启了sidecar注入,但被白名单排除", zap.String("reason", reason))
+	event.Recorder.Event(dp, corev1.EventTypeNormal, deploy.ReasonExcludedByPolicy, message)
+	err := deploy.NewDeploy(d.K8sClient, d.FluentBit, d.Sidecar, d.Controller).RecordStatus(ctx, dp, deploy.StatusExcluded, "")
+	if err != nil {
+		lg.FromContext(ctx).Error("记录注入状态失败", zap.Error(err))
+	}
+}
+
+// cleanup deployment删除后清理kube-sidecar为其创建的secret与PodMonitor
+func (d *deployment) cleanup(ctx context.Context, namespace, name string) {
+	if err := secret.NewSecret(d.K8sClient, d.Controller).Delete(ctx, namespace, name); err != nil {
+		lg.FromContext(ctx).Error("deployment已删除,清理secret失败", zap.Error(err))
+	}
+	d.deletePodMonitor(ctx, namespace, name)
+}
+
+// applyPodMonitor 为已注入sidecar的deployment创建或更新PodMonitor,PodMonitor随deployment一起删除
+func (d *deployment) applyPodMonitor(ctx context.Context, dp *appsv1.Deployment) {
+	if !d.Monitoring.PodMonitor || dp.Spec.Selector == nil {
+		return
+	}
+	owner := &metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       dp.Name,
+		UID:        dp.UID,
+	}
+	monitorCtx, span := tracing.Start(ctx, "podmonitor.apply")
+	err := monitor.NewPodMonitor(d.K8sClient, d.Monitoring, d.Controller).
+		Apply(monitorCtx, monitor.Build(dp.ObjectMeta, *dp.Spec.Selector, owner, d.Monitoring))
+	tracing.End(span, err)
+	if err != nil {
+		lg.FromContext(ctx).Error("创建PodMonitor失败", zap.Error(err))
+		return
+	}
+	d.monitored[dp.Namespace+"/"+dp.Name] = true
+}
+
+// deletePodMonitor 移除sidecar或删除deployment后清理PodMonitor,只删除带有工作负载标记的对象
+func (d *deployment) deletePodMonitor(ctx context.Context, namespace, name string) {
+	if !d.Monitoring.PodMonitor {
+		return
+	}
+	monitorCtx, span := tracing.Start(ctx, "podmonitor.delete")
+	err := monitor.NewPodMonitor(d.K8sClient, d.Monitoring, d.Controller).Delete(monitorCtx, namespace, name)
+	tracing.End(span, err)
+	if err != nil {
+		lg.FromContext(ctx).Error("删除PodMonitor失败", zap.Error(err))
 	}
 }
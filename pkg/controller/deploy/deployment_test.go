@@ -0,0 +1,182 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deploy
+
+import (
+	promfake "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/fluent"
+	"kube-sidecar/pkg/clientset/jaeger"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/clientset/monitoring"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/clientset/workload"
+	"kube-sidecar/pkg/model/deploy"
+	"kube-sidecar/pkg/model/event"
+	"strings"
+	"testing"
+)
+
+func TestReconcileDryRunReportsOnce(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	recorder := record.NewFakeRecorder(10)
+	event.Recorder = recorder
+	defer func() { event.Recorder = &record.FakeRecorder{} }()
+	dp := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "app",
+			Namespace:  "default",
+			Generation: 1,
+			Annotations: map[string]string{
+				deploy.AnnotationSidecar:                        "true",
+				"deployment.kubernetes.io/sidecar.backend":      "elasticsearch",
+				"deployment.kubernetes.io/sidecar.outputEsHost": "es",
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "nginx"}},
+			}}},
+	}
+	clientset := fake.NewSimpleClientset(dp.DeepCopy())
+	// fake clientset不支持服务端dry-run,dry-run写操作不保存
+	for _, resource := range []string{"deployments", "secrets"} {
+		clientset.PrependReactor("*", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			switch a := action.(type) {
+			case k8stesting.CreateAction:
+				return true, a.GetObject(), nil
+			case k8stesting.UpdateAction:
+				return true, a.GetObject(), nil
+			}
+			return false, nil, nil
+		})
+	}
+	options := controller.NewControllerOptions()
+	options.DryRun = true
+	monitoringOptions := monitoring.NewMonitoringOptions()
+	monitoringOptions.PodMonitor = true
+	prometheus := promfake.NewSimpleClientset()
+	d := NewDeployment(kubernetes.NewFakeClientSets(clientset, nil, prometheus, "", nil), *fluent.NewFluentBitOptions(),
+		*sidecar.NewSidecarOptions(), *jaeger.NewJaegerOptions(), *workload.NewWhiteListOptions(), *options, *monitoringOptions).(*deployment)
+
+	dryRunEvents := func() int {
+		n := 0
+		for len(recorder.Events) > 0 {
+			if strings.HasPrefix(<-recorder.Events, corev1.EventTypeNormal+" "+deploy.ReasonDryRun) {
+				n++
+			}
+		}
+		return n
+	}
+	// 重复的事件与watch重新建立不重复报告
+	for i := 0; i < 3; i++ {
+		if err := d.reconcile("default/app"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := dryRunEvents(); n != 1 {
+		t.Errorf("DryRun events = %d, want 1", n)
+	}
+	// 注入配置变化后重新报告
+	dp.Annotations["deployment.kubernetes.io/sidecar.outputEsHost"] = "es-2"
+	if err := clientset.Tracker().Update(appsv1.SchemeGroupVersion.WithResource("deployments"), dp, "default"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reconcile("default/app"); err != nil {
+		t.Fatal(err)
+	}
+	if n := dryRunEvents(); n != 1 {
+		t.Errorf("DryRun events after annotation change = %d, want 1", n)
+	}
+	// dry-run模式下不创建PodMonitor
+	if actions := prometheus.Actions(); len(actions) != 0 {
+		t.Errorf("unexpected PodMonitor actions %v", actions)
+	}
+}
+
+func TestReconcileDeletesPodMonitorOnlyAfterInjection(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	newDeployment := func(name string, annotations map[string]string, containers ...string) *appsv1.Deployment {
+		dp := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			},
+		}
+		for _, c := range containers {
+			dp.Spec.Template.Spec.Containers = append(dp.Spec.Template.Spec.Containers, corev1.Container{Name: c, Image: "nginx"})
+		}
+		return dp
+	}
+	injected := map[string]string{deploy.AnnotationSidecar: "true", deploy.AnnotationInjectedContainer: "sidecar"}
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		// before 处理该工作负载前已处理过的版本
+		before *appsv1.Deployment
+		delete bool
+	}{
+		{name: "not opted in", deployment: newDeployment("plain", nil, "app")},
+		{name: "injection markers left behind", deployment: newDeployment("marked", map[string]string{deploy.AnnotationInjectedContainer: "sidecar"}, "app"), delete: true},
+		{name: "injected status left behind", deployment: newDeployment("status", map[string]string{deploy.AnnotationStatus: deploy.StatusInjected}, "app"), delete: true},
+		{name: "sidecar removed", deployment: newDeployment("removed", nil, "app"), before: newDeployment("removed", injected, "app", "sidecar"), delete: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tt.deployment.DeepCopy())
+			prometheus := promfake.NewSimpleClientset()
+			monitoringOptions := monitoring.NewMonitoringOptions()
+			monitoringOptions.PodMonitor = true
+			d := NewDeployment(kubernetes.NewFakeClientSets(clientset, nil, prometheus, "", nil), *fluent.NewFluentBitOptions(),
+				*sidecar.NewSidecarOptions(), *jaeger.NewJaegerOptions(), *workload.NewWhiteListOptions(),
+				*controller.NewControllerOptions(), *monitoringOptions).(*deployment)
+			key := "default/" + tt.deployment.Name
+			if tt.before != nil {
+				gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+				if err := clientset.Tracker().Update(gvr, tt.before, "default"); err != nil {
+					t.Fatal(err)
+				}
+				if err := d.reconcile(key); err != nil {
+					t.Fatal(err)
+				}
+				if err := clientset.Tracker().Update(gvr, tt.deployment, "default"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			prometheus.ClearActions()
+			if err := d.reconcile(key); err != nil {
+				t.Fatal(err)
+			}
+			deleted := false
+			for _, action := range prometheus.Actions() {
+				deleted = deleted || action.GetResource().Resource == "podmonitors"
+			}
+			if deleted != tt.delete {
+				t.Errorf("PodMonitor cleanup = %v, want %v (actions %v)", deleted, tt.delete, prometheus.Actions())
+			}
+		})
+	}
+}
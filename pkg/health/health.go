@@ -0,0 +1,190 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	lg "kube-sidecar/pkg/clientset/logging"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted 敏感配置项脱敏后的值
+const Redacted = "******"
+
+// sensitiveKeys 配置项名称包含这些关键字时在/debug/config中脱敏,header包含认证信息,如tracing.headers
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "credential", "authorization", "header"}
+
+var (
+	mu          sync.RWMutex
+	readyChecks = map[string]func() error{}
+)
+
+// AddReadyCheck 注册就绪检查,所有检查通过时/readyz返回200
+func AddReadyCheck(name string, check func() error) {
+	mu.Lock()
+	defer mu.Unlock()
+	readyChecks[name] = check
+}
+
+// Ready 执行全部就绪检查,返回失败的检查
+func Ready() error {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(readyChecks))
+	for name := range readyChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if err := readyChecks[name](); err != nil {
+			errs = append(errs, errors.New(name+": "+err.Error()))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Serve 在address上启动健康检查与调试HTTP服务,config为/debug/config返回的生效配置,
+// enablePprof为true时注册/debug/pprof,enableLogLevel为true时注册/debug/loglevel,
+// 两者没有认证,健康检查端口在集群内可以访问,默认不开启。返回停止函数,address为空时不启动
+func Serve(address string, config interface{}, enablePprof, enableLogLevel bool) func() {
+	if address == "" {
+		return func() {}
+	}
+	server := &http.Server{Addr: address, Handler: handler(config, enablePprof, enableLogLevel)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			lg.Logger.Error("健康检查服务启动失败,错误信息" + err.Error())
+		}
+	}()
+	lg.Logger.Info("健康检查服务监听地址" + address)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}
+}
+
+// handler 注册健康检查与调试接口
+func handler(config interface{}, enablePprof, enableLogLevel bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/debug/config", func(w http.ResponseWriter, r *http.Request) {
+		data, err := Redact(config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	})
+	if enableLogLevel {
+		// GET查询当前日志级别,PUT {"level":"debug"}运行中修改
+		mux.Handle("/debug/loglevel", lg.Level)
+	}
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// Redact 将配置序列化为JSON,名称包含password、token等关键字的非空配置项替换为******
+func Redact(config interface{}) ([]byte, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(redact(value), "", "  ")
+}
+
+// redact 递归脱敏
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if sensitive(key) {
+				v[key] = redactAll(child)
+				continue
+			}
+			v[key] = redact(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redact(child)
+		}
+	}
+	return value
+}
+
+// redactAll 替换敏感配置项下的全部非空值,保留map的key便于确认配置了哪些项
+func redactAll(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return v
+	case string:
+		if v == "" {
+			return v
+		}
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redactAll(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactAll(child)
+		}
+		return v
+	}
+	return Redacted
+}
+
+// sensitive 判断配置项名称是否为敏感信息
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
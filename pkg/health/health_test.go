@@ -0,0 +1,76 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"kube-sidecar/pkg/clientset/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	config := map[string]interface{}{
+		"fluentBitConfig": map[string]interface{}{
+			"outputEsUser":     "elastic",
+			"outputEsPassword": "changeme",
+		},
+		"otlp": []interface{}{map[string]interface{}{"token": "abc", "endpoint": "collector:4317"}},
+		"tracing": map[string]interface{}{
+			"headers":  map[string]interface{}{"authorization": "Bearer xyz", "x-api-key": "k123"},
+			"endpoint": "otel:4317",
+		},
+	}
+	data, err := Redact(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, leaked := range []string{"changeme", "abc", "Bearer xyz", "k123"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("secret %q not redacted:\n%s", leaked, out)
+		}
+	}
+	for _, kept := range []string{"elastic", "collector:4317", "otel:4317", "authorization"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("lost %q:\n%s", kept, out)
+		}
+	}
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	defer logging.Level.SetLevel(logging.Level.Level())
+	tests := []struct {
+		name    string
+		enabled bool
+		want    int
+	}{
+		{"disabled by default", false, http.StatusNotFound},
+		{"enabled", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/debug/loglevel", strings.NewReader(`{"level":"debug"}`))
+			w := httptest.NewRecorder()
+			handler(map[string]string{}, false, tt.enabled).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kube-sidecar/pkg/model/deploy"
+)
+
+// Inject 将离线注入的结果写回原始文档,meta与spec为注入后的工作负载元数据与pod模版。
+// 只按注入标记追加sidecar容器、卷、卷挂载与标记注释,并设置被修改的启动命令与优雅停止时间;
+// 直接编辑YAML节点,其他字段(包括当前client-go版本不认识的字段)与注释保持原样
+func Inject(doc string, meta *metav1.ObjectMeta, spec *corev1.PodSpec) (string, error) {
+	marker, ok, err := deploy.ParseMarker(meta.Annotations)
+	if err != nil || !ok {
+		return doc, err
+	}
+	var root yaml.Node
+	if err = yaml.Unmarshal([]byte(doc), &root); err != nil {
+		return doc, err
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return doc, fmt.Errorf("文档不是kubernetes对象")
+	}
+	obj := root.Content[0]
+	path, ok := podSpecPaths[scalar(lookup(obj, "kind"))]
+	if !ok {
+		return doc, fmt.Errorf("不支持的工作负载类型 %s", scalar(lookup(obj, "kind")))
+	}
+	annotations := mapping(obj, "metadata", "annotations")
+	for _, key := range deploy.MarkerAnnotations {
+		if value, ok := meta.Annotations[key]; ok {
+			setString(annotations, key, value)
+		}
+	}
+	podSpec := mapping(obj, path...)
+	// 应用容器新增的卷挂载与完成模式包装后的启动命令
+	for _, c := range spec.Containers {
+		item := findItem(lookup(podSpec, "containers"), c.Name)
+		if item == nil {
+			continue
+		}
+		for _, vm := range c.VolumeMounts {
+			if !contains(marker.Mounts[c.Name], vm.MountPath) {
+				continue
+			}
+			if _, err = appendItem(item, "volumeMounts", vm); err != nil {
+				return doc, err
+			}
+		}
+		if _, wrapped := marker.Commands[c.Name]; wrapped {
+			setStrings(item, "command", c.Command)
+			setStrings(item, "args", c.Args)
+		}
+	}
+	// sidecar容器,原生sidecar以restartPolicy Always的初始化容器注入
+	for _, c := range spec.InitContainers {
+		if c.Name != marker.Container {
+			continue
+		}
+		item, err := appendItem(podSpec, "initContainers", c)
+		if err != nil {
+			return doc, err
+		}
+		setString(item, "restartPolicy", "Always")
+	}
+	for _, c := range spec.Containers {
+		if c.Name != marker.Container {
+			continue
+		}
+		if _, err = appendItem(podSpec, "containers", c); err != nil {
+			return doc, err
+		}
+	}
+	for _, v := range spec.Volumes {
+		if !contains(marker.Volumes, v.Name) {
+			continue
+		}
+		if _, err = appendItem(podSpec, "volumes", v); err != nil {
+			return doc, err
+		}
+	}
+	if marker.GracePeriodChanged && spec.TerminationGracePeriodSeconds != nil {
+		setInt(podSpec, "terminationGracePeriodSeconds", *spec.TerminationGracePeriodSeconds)
+	}
+	return encode(&root)
+}
+
+// mapping 按路径获取mapping节点,不存在或为空值时创建
+func mapping(node *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		next := lookup(node, key)
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, next)
+		} else if next.Kind != yaml.MappingNode {
+			*next = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		node = next
+	}
+	return node
+}
+
+// findItem 获取序列中name为指定值的元素
+func findItem(seq *yaml.Node, name string) *yaml.Node {
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, item := range seq.Content {
+		if scalar(lookup(item, "name")) == name {
+			return item
+		}
+	}
+	return nil
+}
+
+// appendItem 将对象转换为YAML节点追加到mapping节点中key对应的序列,序列不存在时创建
+func appendItem(node *yaml.Node, key string, value interface{}) (*yaml.Node, error) {
+	item, err := toNode(value)
+	if err != nil {
+		return nil, err
+	}
+	seq := lookup(node, key)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		removeKey(node, key)
+		seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, seq)
+	}
+	seq.Content = append(seq.Content, item)
+	return item, nil
+}
+
+// toNode 按kubernetes的JSON序列化规则将对象转换为YAML节点,省略空值字段,mapping的key按字母排序
+func toNode(value interface{}) (*yaml.Node, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err = node.Encode(v); err != nil {
+		return nil, err
+	}
+	// 与手写清单保持一致,name放在第一个字段
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "name" {
+			name := append([]*yaml.Node(nil), node.Content[i:i+2]...)
+			node.Content = append(name, append(node.Content[:i], node.Content[i+2:]...)...)
+			break
+		}
+	}
+	return &node, nil
+}
+
+// setString 设置mapping节点中key的字符串值,key不存在时追加
+func setString(node *yaml.Node, key, value string) {
+	if v := lookup(node, key); v != nil {
+		*v = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		return
+	}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifest
+
+import (
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/fluent"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/model/deploy"
+	"strings"
+	"testing"
+)
+
+// appDeployment 使用当前client-go版本不认识的字段:应用自己的原生sidecar与resizePolicy
+const appDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: shop
+  annotations:
+    deployment.kubernetes.io/sidecar: "true"
+    deployment.kubernetes.io/sidecar.backend: elasticsearch
+    deployment.kubernetes.io/sidecar.outputEsHost: es
+    deployment.kubernetes.io/sidecar.inputLogPath: /var/log/app
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      initContainers:
+        # 应用自己的原生sidecar
+        - name: proxy
+          image: envoy
+          restartPolicy: Always
+      containers:
+        - name: app
+          image: nginx
+          resizePolicy:
+            - resourceName: cpu
+              restartPolicy: NotRequired
+`
+
+func TestInjectKeepsUnknownFields(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	obj, err := Decode([]byte(appDeployment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, spec, _ := PodTemplate(obj)
+	d := deploy.NewDeploy(kubernetes.NewFakeClientSets(nil, nil, nil, "", nil), *fluent.NewFluentBitOptions(), *sidecar.NewSidecarOptions(), *controller.NewControllerOptions())
+	if _, _, err = d.Inject(meta, spec); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Inject(appDeployment, meta, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kept := range []string{
+		"- name: proxy\n          image: envoy\n          restartPolicy: Always",
+		"resizePolicy:\n            - resourceName: cpu\n              restartPolicy: NotRequired",
+		"# 应用自己的原生sidecar",
+		"- name: sidecar\n",
+		"- name: sidecar-config\n          secret:",
+		"- name: sidecar-logs\n              mountPath: /var/log/app",
+		"sidecar.kube-sidecar.io/injected-container: sidecar",
+	} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("output missing %q:\n%s", kept, out)
+		}
+	}
+	for _, noise := range []string{"strategy:", "resources: {}", "creationTimestamp", "status:"} {
+		if strings.Contains(out, noise) {
+			t.Errorf("output contains %q:\n%s", noise, out)
+		}
+	}
+	restored, result, err := Uninject(out)
+	if err != nil || result != Uninjected {
+		t.Fatalf("result = %d, err = %v", result, err)
+	}
+	if restored != appDeployment {
+		t.Errorf("uninject after inject =\n%s\nwant\n%s", restored, appDeployment)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"kube-sidecar/pkg/model/container"
+	"kube-sidecar/pkg/model/deploy"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// 输出格式
+const (
+	FormatYAML  = "yaml"
+	FormatJSON  = "json"
+	FormatTable = "table"
+)
+
+// Split 按YAML文档分隔符拆分多文档清单,保留每个文档的原始内容
+func Split(data []byte) []string {
+	var (
+		docs    []string
+		current strings.Builder
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimRight(line, " \t\r") == "---" || strings.HasPrefix(line, "--- ") {
+			docs = append(docs, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	docs = append(docs, current.String())
+	// 去除只包含空白与注释的文档
+	var result []string
+	for _, doc := range docs {
+		if !IsEmpty(doc) {
+			result = append(result, doc)
+		}
+	}
+	return result
+}
+
+// IsEmpty 文档是否只包含空白与注释
+func IsEmpty(doc string) bool {
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+// Decode 将单个YAML或JSON文档解析为kubernetes对象
+func Decode(doc []byte) (runtime.Object, error) {
+	data, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	return obj, err
+}
+
+// PodTemplate 获取工作负载的元数据与pod模版,不支持的对象类型返回false
+func PodTemplate(obj runtime.Object) (*metav1.ObjectMeta, *corev1.PodSpec, bool) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &o.ObjectMeta, &o.Spec.Template.Spec, true
+	case *appsv1.StatefulSet:
+		return &o.ObjectMeta, &o.Spec.Template.Spec, true
+	case *appsv1.DaemonSet:
+		return &o.ObjectMeta, &o.Spec.Template.Spec, true
+	case *batchv1.Job:
+		return &o.ObjectMeta, &o.Spec.Template.Spec, true
+	case *batchv1.CronJob:
+		return &o.ObjectMeta, &o.Spec.JobTemplate.Spec.Template.Spec, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// Marshal 将对象序列化为YAML或JSON
+func Marshal(obj interface{}, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatYAML, "":
+		return yaml.Marshal(obj)
+	default:
+		return nil, fmt.Errorf("不支持的输出格式 %s", format)
+	}
+}
+
+// MarshalObject 将kubernetes对象序列化为YAML,去除creationTimestamp与status等服务端字段,
+// 以初始化容器注入的原生sidecar设置restartPolicy Always
+func MarshalObject(obj runtime.Object) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	delete(m, "status")
+	removeCreationTimestamp(m)
+	if meta, spec, ok := PodTemplate(obj); ok {
+		if marker, injected, _ := deploy.ParseMarker(meta.Annotations); injected {
+			for _, c := range spec.InitContainers {
+				if c.Name == marker.Container {
+					container.SetRestartPolicyAlways(m, c.Name)
+				}
+			}
+		}
+	}
+	return yaml.Marshal(m)
+}
+
+// removeCreationTimestamp 递归删除metadata中为空的creationTimestamp
+func removeCreationTimestamp(m map[string]interface{}) {
+	for k, v := range m {
+		child, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if k == "metadata" {
+			if ts, ok := child["creationTimestamp"]; ok && ts == nil {
+				delete(child, "creationTimestamp")
+			}
+		}
+		removeCreationTimestamp(child)
+	}
+}
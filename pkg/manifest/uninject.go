@@ -0,0 +1,236 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifest
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v3"
+	"kube-sidecar/pkg/model/deploy"
+	"kube-sidecar/pkg/model/secret"
+	"strconv"
+)
+
+// 文档的uninject结果
+const (
+	Unchanged = iota
+	Uninjected
+	Removed
+)
+
+// podSpecPaths 支持uninject的工作负载类型与pod模版路径
+var podSpecPaths = map[string][]string{
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// Uninject 移除文档中kube-sidecar根据标记注释添加的容器、卷与卷挂载,
+// kube-sidecar生成的secret返回Removed,未注入的文档返回Unchanged且不修改内容。
+// 直接编辑YAML节点,保留原始字段顺序与注释
+func Uninject(doc string) (string, int, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(doc), &root); err != nil {
+		return doc, Unchanged, err
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return doc, Unchanged, nil
+	}
+	obj := root.Content[0]
+	annotations := lookup(obj, "metadata", "annotations")
+	switch scalar(lookup(obj, "kind")) {
+	case "Secret":
+		if lookup(annotations, secret.AnnotationWorkload) != nil {
+			return "", Removed, nil
+		}
+		return doc, Unchanged, nil
+	}
+	path, ok := podSpecPaths[scalar(lookup(obj, "kind"))]
+	if !ok {
+		return doc, Unchanged, nil
+	}
+	marker, ok, err := deploy.ParseMarker(stringMap(annotations))
+	if err != nil || !ok {
+		return doc, Unchanged, err
+	}
+	spec := lookup(obj, path...)
+	for _, key := range []string{"containers", "initContainers"} {
+		containers := lookup(spec, key)
+		removeItems(spec, key, func(item *yaml.Node) bool {
+			return scalar(lookup(item, "name")) == marker.Container
+		})
+		if containers == nil {
+			continue
+		}
+		for _, c := range containers.Content {
+			name := scalar(lookup(c, "name"))
+			// 恢复完成模式包装前的启动命令
+			if command, wrapped := marker.Commands[name]; wrapped {
+				setStrings(c, "command", command.Command)
+				setStrings(c, "args", command.Args)
+			}
+			paths := marker.Mounts[name]
+			removeItems(c, "volumeMounts", func(item *yaml.Node) bool {
+				return contains(paths, scalar(lookup(item, "mountPath")))
+			})
+		}
+	}
+	removeItems(spec, "volumes", func(item *yaml.Node) bool {
+		return contains(marker.Volumes, scalar(lookup(item, "name")))
+	})
+	// 恢复注入前的pod优雅停止时间
+	if marker.GracePeriodChanged {
+		if marker.GracePeriod == nil {
+			removeKey(spec, "terminationGracePeriodSeconds")
+		} else {
+			setInt(spec, "terminationGracePeriodSeconds", *marker.GracePeriod)
+		}
+	}
+	for _, key := range append(deploy.MarkerAnnotations, deploy.StatusAnnotations...) {
+		removeKey(annotations, key)
+	}
+	out, err := encode(&root)
+	if err != nil {
+		return doc, Unchanged, err
+	}
+	return out, Uninjected, nil
+}
+
+// encode 将YAML节点序列化为文档,使用两个空格缩进
+func encode(root *yaml.Node) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// lookup 按路径获取mapping节点中的子节点,不存在时返回nil
+func lookup(node *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// scalar 获取标量节点的值
+func scalar(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+// stringMap 将mapping节点转换为map
+func stringMap(node *yaml.Node) map[string]string {
+	m := make(map[string]string)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return m
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		m[node.Content[i].Value] = node.Content[i+1].Value
+	}
+	return m
+}
+
+// removeItems 删除mapping节点中key对应序列里满足条件的元素,序列为空时删除该key
+func removeItems(node *yaml.Node, key string, match func(item *yaml.Node) bool) {
+	seq := lookup(node, key)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		return
+	}
+	items := seq.Content[:0]
+	for _, item := range seq.Content {
+		if !match(item) {
+			items = append(items, item)
+		}
+	}
+	seq.Content = items
+	if len(items) == 0 {
+		removeKey(node, key)
+	}
+}
+
+// removeKey 删除mapping节点中的key
+func removeKey(node *yaml.Node, key string) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// setInt 设置mapping节点中key的整数值,key不存在时追加
+func setInt(node *yaml.Node, key string, value int64) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	if v := lookup(node, key); v != nil {
+		v.Kind, v.Tag, v.Value = yaml.ScalarNode, "!!int", strconv.FormatInt(value, 10)
+		return
+	}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(value, 10)})
+}
+
+// setStrings 设置mapping节点中key的字符串序列,值为空时删除该key
+func setStrings(node *yaml.Node, key string, values []string) {
+	if len(values) == 0 {
+		removeKey(node, key)
+		return
+	}
+	seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, v := range values {
+		seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v})
+	}
+	if v := lookup(node, key); v != nil {
+		*v = *seq
+		return
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, seq)
+}
+
+// contains 判断字符串是否在列表中
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifest
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/fluent"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/model/container"
+	"kube-sidecar/pkg/model/deploy"
+	"strings"
+	"testing"
+)
+
+const injectedDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    deployment.kubernetes.io/sidecar: "true"
+    sidecar.kube-sidecar.io/injected-container: sidecar
+    sidecar.kube-sidecar.io/injected-mounts: '{"app":["/var/log/app"]}'
+    sidecar.kube-sidecar.io/injected-secret: app-sidecar
+    sidecar.kube-sidecar.io/injected-volumes: sidecar-logs,sidecar-config
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: nginx
+          volumeMounts:
+            # 应用自己的数据卷
+            - name: data
+              mountPath: /data
+            - name: sidecar-logs
+              mountPath: /var/log/app
+        - name: sidecar
+          image: fluent-bit
+      volumes:
+        - name: data
+          emptyDir: {}
+        - name: sidecar-logs
+          emptyDir: {}
+        - name: sidecar-config
+          secret:
+            secretName: app-sidecar
+`
+
+func TestUninject(t *testing.T) {
+	out, result, err := Uninject(injectedDeployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != Uninjected {
+		t.Fatalf("result = %d, want %d", result, Uninjected)
+	}
+	for _, removed := range []string{"sidecar-logs", "sidecar-config", "name: sidecar", "kube-sidecar.io"} {
+		if strings.Contains(out, removed) {
+			t.Errorf("output still contains %q:\n%s", removed, out)
+		}
+	}
+	for _, kept := range []string{"deployment.kubernetes.io/sidecar: \"true\"", "name: data", "mountPath: /data", "# 应用自己的数据卷"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("output lost %q:\n%s", kept, out)
+		}
+	}
+}
+
+func TestUninjectUnchanged(t *testing.T) {
+	docs := []string{
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg\n",
+		"apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\n",
+		"apiVersion: apps/v1\nkind: Deployment\nmetadata:\n    name: app\nspec: {}\n",
+	}
+	for _, doc := range docs {
+		out, result, err := Uninject(doc)
+		if err != nil || result != Unchanged || out != doc {
+			t.Errorf("Uninject(%q) = %q, %d, %v", doc, out, result, err)
+		}
+	}
+	_, result, err := Uninject("apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-sidecar\n  annotations:\n    sidecar.kube-sidecar.io/workload: app\n")
+	if err != nil || result != Removed {
+		t.Errorf("generated secret: result = %d, err = %v", result, err)
+	}
+}
+
+const injectedCronJob = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+  annotations:
+    sidecar.kube-sidecar.io/injected-container: sidecar
+    sidecar.kube-sidecar.io/injected-mounts: '{"app":["/kube-sidecar/completion"]}'
+    sidecar.kube-sidecar.io/injected-secret: report-sidecar
+    sidecar.kube-sidecar.io/injected-volumes: sidecar-completion
+    sidecar.kube-sidecar.io/original-commands: '{"app":{"command":["report"]}}'
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: app
+              command: [/bin/sh, -c, 'touch done', kube-sidecar-wrapper]
+              args: [report]
+              volumeMounts:
+                - name: sidecar-completion
+                  mountPath: /kube-sidecar/completion
+            - name: sidecar
+          volumes:
+            - name: sidecar-completion
+              emptyDir: {}
+`
+
+func TestUninjectCompletion(t *testing.T) {
+	out, result, err := Uninject(injectedCronJob)
+	if err != nil || result != Uninjected {
+		t.Fatalf("result = %d, err = %v", result, err)
+	}
+	for _, removed := range []string{"sidecar-completion", "kube-sidecar-wrapper", "args:", "name: sidecar"} {
+		if strings.Contains(out, removed) {
+			t.Errorf("output still contains %q:\n%s", removed, out)
+		}
+	}
+	if !strings.Contains(out, "command:\n                - report") {
+		t.Errorf("command not restored:\n%s", out)
+	}
+}
+
+// TestUninjectMatchesDeploy 清单uninject与控制器uninject对同一注入结果的处理需要一致,并恢复注入前的工作负载
+func TestUninjectMatchesDeploy(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	grace := int64(5)
+	tests := []struct {
+		name   string
+		native string
+		obj    runtime.Object
+	}{
+		{"deployment", container.NativeDisabled, &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{"deployment.kubernetes.io/sidecar": "true"}},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				TerminationGracePeriodSeconds: &grace,
+				Containers:                    []corev1.Container{{Name: "app", Image: "nginx", VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}}}},
+				Volumes:                       []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+			}}},
+		}},
+		{"completion job", container.NativeDisabled, &batchv1.Job{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+			ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "default", Annotations: map[string]string{"deployment.kubernetes.io/sidecar": "true"}},
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				Containers:    []corev1.Container{{Name: "app", Image: "report", Command: []string{"report"}, Args: []string{"--all"}}},
+			}}},
+		}},
+		{"native sidecar", container.NativeEnabled, &appsv1.StatefulSet{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", Annotations: map[string]string{"deployment.kubernetes.io/sidecar": "true"}},
+			Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Image: "busybox"}},
+				Containers:     []corev1.Container{{Name: "db", Image: "postgres"}},
+			}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := *sidecar.NewSidecarOptions()
+			options.NativeSidecar = tt.native
+			d := deploy.NewDeploy(kubernetes.NewFakeClientSets(nil, nil, nil, "", nil), *fluent.NewFluentBitOptions(), options, *controller.NewControllerOptions())
+			injected := tt.obj.DeepCopyObject()
+			meta, spec, _ := PodTemplate(injected)
+			meta.Annotations["deployment.kubernetes.io/sidecar.backend"] = "elasticsearch"
+			meta.Annotations["deployment.kubernetes.io/sidecar.outputEsHost"] = "es"
+			if _, _, err := d.Inject(meta, spec); err != nil {
+				t.Fatal(err)
+			}
+			doc, err := MarshalObject(injected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, result, err := Uninject(string(doc))
+			if err != nil || result != Uninjected {
+				t.Fatalf("result = %d, err = %v", result, err)
+			}
+			fromManifest, err := Decode([]byte(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifestMeta, manifestSpec, _ := PodTemplate(fromManifest)
+			changed, err := deploy.Uninject(meta, spec, options.Name)
+			if err != nil || !changed {
+				t.Fatalf("changed = %v, err = %v", changed, err)
+			}
+			_, originalSpec, _ := PodTemplate(tt.obj)
+			for source, got := range map[string]*corev1.PodSpec{"manifest": manifestSpec, "deploy": spec} {
+				if !equality.Semantic.DeepEqual(got, originalSpec) {
+					t.Errorf("%s uninject spec = %+v, want %+v", source, got, originalSpec)
+				}
+			}
+			if !equality.Semantic.DeepEqual(manifestMeta.Annotations, meta.Annotations) {
+				t.Errorf("annotations differ: manifest %v, deploy %v", manifestMeta.Annotations, meta.Annotations)
+			}
+		})
+	}
+}
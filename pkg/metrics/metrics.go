@@ -0,0 +1,116 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/util/workqueue"
+	lg "kube-sidecar/pkg/clientset/logging"
+	"net/http"
+	"time"
+)
+
+// Namespace 控制器指标前缀
+const Namespace = "kube_sidecar"
+
+// Registry 控制器指标注册表,包含go运行时与进程指标
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Reconciliations 按结果统计的工作负载处理次数
+	Reconciliations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "reconciliations_total",
+		Help:      "Number of workload reconciliations by result.",
+	}, []string{"result"})
+	// InjectionDuration 注入sidecar并更新工作负载的耗时
+	InjectionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "injection_duration_seconds",
+		Help:      "Latency of injecting the sidecar and updating the workload.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	// Secrets 按操作统计的fluentBit secret写入次数
+	Secrets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "secrets_total",
+		Help:      "Number of fluent-bit Secrets created, updated or deleted.",
+	}, []string{"operation"})
+	// WatchRestarts 工作负载watch断开后重新建立的次数
+	WatchRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "watch_restarts_total",
+		Help:      "Number of times the workload watch was re-established.",
+	})
+	// ConfigReloads 按结果统计的配置文件重新加载次数,运行中只生效日志级别,日志级别无效时记为error
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of configuration reloads by result.",
+	}, []string{"result"})
+)
+
+// 指标标签值
+const (
+	ResultInjected = "injected"
+	ResultSkipped  = "skipped"
+	ResultDeleted  = "deleted"
+	ResultError    = "error"
+	ResultSuccess  = "success"
+	ResultDryRun   = "dry-run"
+
+	OperationCreated = "created"
+	OperationUpdated = "updated"
+	OperationDeleted = "deleted"
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		Reconciliations,
+		InjectionDuration,
+		Secrets,
+		WatchRestarts,
+		ConfigReloads,
+	)
+	workqueue.SetProvider(workqueueProvider{})
+}
+
+// Serve 在address上启动指标HTTP服务,暴露/metrics,返回停止函数,address为空时不启动
+func Serve(address string) func() {
+	if address == "" {
+		return func() {}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			lg.Logger.Error("指标服务启动失败,错误信息" + err.Error())
+		}
+	}()
+	lg.Logger.Info("指标服务监听地址" + address)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/util/workqueue"
+	"testing"
+)
+
+// gather 获取注册表中指标的值,按name标签过滤,不存在时返回false
+func gather(t *testing.T, name, queue string) (float64, bool) {
+	t.Helper()
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if queue != "" && !hasLabel(m, "name", queue) {
+				continue
+			}
+			switch {
+			case m.Counter != nil:
+				return m.Counter.GetValue(), true
+			case m.Gauge != nil:
+				return m.Gauge.GetValue(), true
+			case m.Histogram != nil:
+				return float64(m.Histogram.GetSampleCount()), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// hasLabel 判断指标是否带有指定的标签值
+func hasLabel(m *dto.Metric, name, value string) bool {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name && l.GetValue() == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWorkqueueMetrics(t *testing.T) {
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	defer queue.ShutDown()
+	queue.Add("default/web")
+	queue.Add("default/api")
+	item, _ := queue.Get()
+	queue.AddRateLimited(item)
+	queue.Done(item)
+	cases := []struct {
+		name string
+		want float64
+	}{
+		{"kube_sidecar_workqueue_depth", 1},
+		{"kube_sidecar_workqueue_adds_total", 2},
+		{"kube_sidecar_workqueue_retries_total", 1},
+		{"kube_sidecar_workqueue_queue_duration_seconds", 1},
+		{"kube_sidecar_workqueue_work_duration_seconds", 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := gather(t, c.name, "test")
+			if !ok {
+				t.Fatalf("%s not registered", c.name)
+			}
+			if got != c.want {
+				t.Errorf("%s = %v, want %v", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestControllerMetricsRegistered(t *testing.T) {
+	Reconciliations.WithLabelValues(ResultInjected).Inc()
+	InjectionDuration.Observe(0.1)
+	Secrets.WithLabelValues(OperationCreated).Inc()
+	WatchRestarts.Inc()
+	ConfigReloads.WithLabelValues(ResultSuccess).Inc()
+	for _, name := range []string{
+		"kube_sidecar_reconciliations_total",
+		"kube_sidecar_injection_duration_seconds",
+		"kube_sidecar_secrets_total",
+		"kube_sidecar_watch_restarts_total",
+		"kube_sidecar_config_reloads_total",
+		"go_goroutines",
+		"process_cpu_seconds_total",
+	} {
+		if _, ok := gather(t, name, ""); !ok {
+			t.Errorf("%s not registered", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/utils/tools"
+	"path"
+	"strings"
+)
+
+// SentinelFile 应用容器退出后创建的哨兵文件名称
+const SentinelFile = "done"
+
+// wrapperScript 应用容器包装命令,退出后创建哨兵文件并保留原退出码。sh作为PID 1不会把信号转发给子进程,
+// pod删除或超过activeDeadlineSeconds时通过trap将SIGTERM/SIGINT转发给应用,wait被信号打断后继续等待应用退出
+const wrapperScript = `rm -f %[1]s
+"$@" & pid=$!
+trap 'kill -TERM $pid 2>/dev/null' TERM INT
+wait $pid; code=$?
+while kill -0 $pid 2>/dev/null; do wait $pid; code=$?; done
+touch %[1]s
+exit $code`
+
+// sidecarScript sidecar容器包装命令,哨兵文件出现或收到SIGTERM/SIGINT后向fluentBit发送SIGTERM,
+// fluentBit在Grace时间内刷新缓冲数据后退出
+const sidecarScript = `%[1]s & pid=$!
+trap 'kill -TERM $pid 2>/dev/null' TERM INT
+while [ ! -f %[2]s ]; do
+  if ! kill -0 $pid 2>/dev/null; then wait $pid; exit $?; fi
+  sleep 1
+done
+kill -TERM $pid
+wait $pid
+exit 0`
+
+// Completion 为Job等批处理工作负载注入完成模式:应用容器与sidecar共享哨兵文件目录,
+// 应用容器退出后sidecar刷新剩余日志并以0退出。包装后的应用命令由/bin/sh执行,应用镜像需要包含/bin/sh,
+// 不包含时通过注释sidecar.completionWrap关闭包装。未设置command的应用容器无法包装,
+// 只挂载哨兵目录并返回警告,需要应用退出时自行创建哨兵文件
+func Completion(spec *corev1.PodSpec, container *corev1.Container, options sidecar.Options, targets []string, wrap bool) []string {
+	var warnings []string
+	sentinel := path.Join(options.CompletionPath, SentinelFile)
+	mount := corev1.VolumeMount{Name: options.CompletionVolumeName, MountPath: options.CompletionPath}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         options.CompletionVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		if len(targets) > 0 && !tools.WhetherExists(c.Name, targets) {
+			continue
+		}
+		c.VolumeMounts = append(c.VolumeMounts, mount)
+		if !wrap {
+			continue
+		}
+		if len(c.Command) == 0 {
+			warnings = append(warnings, fmt.Sprintf("容器%s未设置command,无法自动包装,请在应用退出时创建哨兵文件%s", c.Name, sentinel))
+			continue
+		}
+		c.Args = append(append([]string(nil), c.Command...), c.Args...)
+		c.Command = []string{"/bin/sh", "-c", fmt.Sprintf(wrapperScript, sentinel), "kube-sidecar-wrapper"}
+	}
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+	container.Image = CompletionImage(container.Image, options.CompletionImage)
+	container.Command = []string{"/bin/sh", "-c", fmt.Sprintf(sidecarScript, shellJoin(append(container.Command, container.Args...)), sentinel)}
+	container.Args = nil
+	return warnings
+}
+
+// CompletionImage 完成模式下sidecar使用的镜像,未配置completionImage时由sidecar镜像推导:
+// fluent-bit镜像使用相同版本的-debug镜像(包含/bin/sh),其他镜像或使用digest时保持不变
+func CompletionImage(image, configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if strings.Contains(image, "@") {
+		return image
+	}
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	if path.Base(name) != "fluent-bit" || strings.HasSuffix(tag, "-debug") {
+		return image
+	}
+	return name + ":" + tag + "-debug"
+}
+
+// shellJoin 将命令行参数用单引号转义后拼接
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
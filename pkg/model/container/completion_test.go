@@ -0,0 +1,119 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompletionImage(t *testing.T) {
+	tests := []struct {
+		image, configured, want string
+	}{
+		{"fluent/fluent-bit:2.2.0", "", "fluent/fluent-bit:2.2.0-debug"},
+		{"registry:5000/fluent/fluent-bit:2.1.0", "", "registry:5000/fluent/fluent-bit:2.1.0-debug"},
+		{"fluent/fluent-bit", "", "fluent/fluent-bit:latest-debug"},
+		{"fluent/fluent-bit:2.1.0-debug", "", "fluent/fluent-bit:2.1.0-debug"},
+		{"fluent/fluent-bit@sha256:abc", "", "fluent/fluent-bit@sha256:abc"},
+		{"example/log-agent:1.0", "", "example/log-agent:1.0"},
+		{"fluent/fluent-bit:2.2.0", "example/shell:1", "example/shell:1"},
+	}
+	for _, tt := range tests {
+		if got := CompletionImage(tt.image, tt.configured); got != tt.want {
+			t.Errorf("CompletionImage(%q, %q) = %q, want %q", tt.image, tt.configured, got, tt.want)
+		}
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"/fluent-bit/bin/fluent-bit", "-c", "/etc/fluent-bit.conf"}, `'/fluent-bit/bin/fluent-bit' '-c' '/etc/fluent-bit.conf'`},
+		{[]string{"echo", "it's", "$HOME `id`"}, `'echo' 'it'\''s' '$HOME ` + "`id`" + `'`},
+		{[]string{""}, `''`},
+	}
+	for _, tt := range tests {
+		if got := shellJoin(tt.args); got != tt.want {
+			t.Errorf("shellJoin(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		targets  []string
+		wrap     bool
+		warnings int
+		wantArgs map[string][]string
+	}{
+		{"wrap all", nil, true, 1, map[string][]string{"app": {"kube-sidecar-wrapper", "/app", "--flag", "it's"}, "worker": {"kube-sidecar-wrapper", "/worker"}}},
+		{"wrap targets", []string{"worker"}, true, 0, map[string][]string{"app": {"--flag", "it's"}, "worker": {"kube-sidecar-wrapper", "/worker"}}},
+		{"no wrap", nil, false, 0, map[string][]string{"app": {"--flag", "it's"}, "worker": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := *sidecar.NewSidecarOptions()
+			spec := &corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app", Command: []string{"/app"}, Args: []string{"--flag", "it's"}},
+				{Name: "worker", Command: []string{"/worker"}},
+				{Name: "no-command"},
+			}}
+			sc := &corev1.Container{Name: options.Name, Image: "fluent/fluent-bit:2.2.0", Command: []string{"/fluent-bit/bin/fluent-bit"}, Args: []string{"-c", "/etc/it's.conf"}}
+			warnings := Completion(spec, sc, options, tt.targets, tt.wrap)
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %v", warnings)
+			}
+			for _, c := range spec.Containers[:2] {
+				args := c.Args
+				if len(c.Command) == 4 && c.Command[0] == "/bin/sh" {
+					if !strings.Contains(c.Command[2], path.Join(options.CompletionPath, SentinelFile)) {
+						t.Errorf("%s wrapper script misses sentinel: %s", c.Name, c.Command[2])
+					}
+					args = append([]string{c.Command[3]}, c.Args...)
+				}
+				if !reflect.DeepEqual(args, tt.wantArgs[c.Name]) {
+					t.Errorf("%s command = %q args = %q", c.Name, c.Command, c.Args)
+				}
+				mounted := false
+				for _, m := range c.VolumeMounts {
+					mounted = mounted || m.Name == options.CompletionVolumeName
+				}
+				if mounted != (len(tt.targets) == 0 || c.Name == tt.targets[0]) {
+					t.Errorf("%s completion volume mounted = %v", c.Name, mounted)
+				}
+			}
+			if sc.Image != "fluent/fluent-bit:2.2.0-debug" || sc.Args != nil {
+				t.Errorf("sidecar image = %s args = %v", sc.Image, sc.Args)
+			}
+			if !strings.HasPrefix(sc.Command[2], `'/fluent-bit/bin/fluent-bit' '-c' '/etc/it'\''s.conf' & pid=$!`) {
+				t.Errorf("sidecar script = %s", sc.Command[2])
+			}
+			if len(spec.Volumes) != 1 || spec.Volumes[0].EmptyDir == nil {
+				t.Errorf("volumes = %v", spec.Volumes)
+			}
+		})
+	}
+}
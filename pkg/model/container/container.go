@@ -17,9 +17,21 @@ limitations under the License.
 package container
 
 import (
+	"fmt"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"kube-sidecar/pkg/clientset/sidecar"
+	"path"
+)
+
+// fluentBit HTTP服务端口与健康检查路径,与SERVICE配置块保持一致
+const (
+	HTTPPort   = 2020
+	HealthPath = "/api/v1/health"
+	// MetricsPortName、MetricsPath PodMonitor采集fluentBit指标使用的端口名称与路径
+	MetricsPortName = "sidecar-metrics"
+	MetricsPath     = "/api/v1/metrics/prometheus"
 )
 
 type container struct {
@@ -27,7 +39,7 @@ type container struct {
 }
 
 type Container interface {
-	Create() *corev1.Container
+	Create() (*corev1.Container, error)
 }
 
 func NewContainer(sidecar sidecar.Options) Container {
@@ -36,27 +48,144 @@ func NewContainer(sidecar sidecar.Options) Container {
 	}
 }
 
-// Create 创建容器方法
-func (s *container) Create() *corev1.Container {
+// Create 创建容器方法,资源配置无效时返回错误
+func (s *container) Create() (*corev1.Container, error) {
+	resources, err := s.resources()
+	if err != nil {
+		return nil, err
+	}
 	return &corev1.Container{
 		Name:            s.sidecar.Name,
 		Image:           s.sidecar.Image,
 		ImagePullPolicy: corev1.PullPolicy(s.sidecar.ImagePullPolicy),
+		// 使用secret挂载的fluentBit配置文件启动
+		Command: []string{"/fluent-bit/bin/fluent-bit"},
+		Args:    []string{"-c", path.Join(s.sidecar.VolumeMount, "fluent-bit.conf")},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          MetricsPortName,
+				ContainerPort: HTTPPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		// 通过Downward API注入pod元数据,供fluentBit配置引用
+		Env: []corev1.EnvVar{
+			fieldRefEnv("POD_NAME", "metadata.name"),
+			fieldRefEnv("POD_NAMESPACE", "metadata.namespace"),
+			fieldRefEnv("NODE_NAME", "spec.nodeName"),
+			fieldRefEnv("POD_IP", "status.podIP"),
+		},
 		VolumeMounts: []corev1.VolumeMount{
 			{
 				Name:      s.sidecar.VolumeName,
 				MountPath: s.sidecar.VolumeMount,
+				ReadOnly:  true,
 			},
 		},
 		// 设置容器的resource资源
-		Resources: corev1.ResourceRequirements{
-			Requests: corev1.ResourceList{
-				corev1.ResourceCPU:    resource.MustParse(s.sidecar.RequestsCPU),
-				corev1.ResourceMemory: resource.MustParse(s.sidecar.RequestsMemory),
+		Resources:       resources,
+		SecurityContext: s.securityContext(),
+		// 就绪探针只检查HTTP服务,避免后端故障导致应用pod不可用;存活探针在output持续失败时重启sidecar
+		ReadinessProbe: s.probe("/"),
+		LivenessProbe:  s.probe(HealthPath),
+		Lifecycle:      s.lifecycle(),
+	}, nil
+}
+
+// resources 解析sidecar容器的资源配置,值为空时不设置
+func (s *container) resources() (corev1.ResourceRequirements, error) {
+	requirements := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+	for _, r := range []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		field string
+		value string
+	}{
+		{requirements.Requests, corev1.ResourceCPU, "requestsCPU", s.sidecar.RequestsCPU},
+		{requirements.Requests, corev1.ResourceMemory, "requestsMemory", s.sidecar.RequestsMemory},
+		{requirements.Limits, corev1.ResourceCPU, "limitCPU", s.sidecar.LimitCPU},
+		{requirements.Limits, corev1.ResourceMemory, "limitMemory", s.sidecar.LimitMemory},
+	} {
+		if r.value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return requirements, fmt.Errorf("sidecar资源配置%s的值%s无效: %w", r.field, r.value, err)
+		}
+		r.list[r.name] = q
+	}
+	return requirements, nil
+}
+
+// securityContext 创建sidecar容器的安全上下文
+func (s *container) securityContext() *corev1.SecurityContext {
+	allowPrivilegeEscalation := false
+	readOnly := s.sidecar.ReadOnly
+	sc := &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnly,
+	}
+	if s.sidecar.RunAsNonRoot {
+		runAsNonRoot := true
+		sc.RunAsNonRoot = &runAsNonRoot
+	}
+	if s.sidecar.RunAsUser > 0 {
+		runAsUser := s.sidecar.RunAsUser
+		sc.RunAsUser = &runAsUser
+	}
+	if len(s.sidecar.DropCapabilities) > 0 {
+		sc.Capabilities = &corev1.Capabilities{}
+		for _, c := range s.sidecar.DropCapabilities {
+			sc.Capabilities.Drop = append(sc.Capabilities.Drop, corev1.Capability(c))
+		}
+	}
+	if s.sidecar.SeccompProfile != "" {
+		sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileType(s.sidecar.SeccompProfile)}
+	}
+	return sc
+}
+
+// probe 创建请求fluentBit HTTP服务的探针,未开启探针时返回nil
+func (s *container) probe(path string) *corev1.Probe {
+	if !s.sidecar.Probes {
+		return nil
+	}
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt(HTTPPort),
 			},
-			Limits: corev1.ResourceList{
-				corev1.ResourceCPU:    resource.MustParse(s.sidecar.LimitCPU),
-				corev1.ResourceMemory: resource.MustParse(s.sidecar.LimitMemory),
+		},
+		PeriodSeconds:    s.sidecar.ProbePeriodSeconds,
+		FailureThreshold: s.sidecar.ProbeFailureThreshold,
+	}
+}
+
+// lifecycle 创建停止前执行的preStop钩子,fluentBit收到SIGTERM后在Grace时间内刷新缓冲数据
+func (s *container) lifecycle() *corev1.Lifecycle {
+	if len(s.sidecar.PreStopCommand) == 0 {
+		return nil
+	}
+	return &corev1.Lifecycle{
+		PreStop: &corev1.Handler{
+			Exec: &corev1.ExecAction{Command: s.sidecar.PreStopCommand},
+		},
+	}
+}
+
+// fieldRefEnv 创建引用pod字段的环境变量
+func fieldRefEnv(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				APIVersion: "v1",
+				FieldPath:  fieldPath,
 			},
 		},
 	}
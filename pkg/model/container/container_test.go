@@ -0,0 +1,164 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreateResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(o *sidecar.Options)
+		wantErr string
+		want    corev1.ResourceRequirements
+	}{
+		{
+			name: "defaults",
+			want: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+			},
+		},
+		{
+			name:   "empty values are not set",
+			modify: func(o *sidecar.Options) { o.LimitCPU, o.LimitMemory = "", "" },
+			want: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+				Limits:   corev1.ResourceList{},
+			},
+		},
+		{name: "invalid requests cpu", modify: func(o *sidecar.Options) { o.RequestsCPU = "250mm" }, wantErr: "requestsCPU"},
+		{name: "invalid limit memory", modify: func(o *sidecar.Options) { o.LimitMemory = "1 Gi" }, wantErr: "limitMemory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := *sidecar.NewSidecarOptions()
+			if tt.modify != nil {
+				tt.modify(&options)
+			}
+			c, err := NewContainer(options).Create()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.Resources, tt.want) {
+				t.Errorf("resources = %v, want %v", c.Resources, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecurityContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(o *sidecar.Options)
+		check  func(t *testing.T, sc *corev1.SecurityContext)
+	}{
+		{
+			name: "minimal",
+			modify: func(o *sidecar.Options) {
+				o.ReadOnly, o.RunAsNonRoot, o.RunAsUser, o.DropCapabilities, o.SeccompProfile = false, false, 0, nil, ""
+			},
+			check: func(t *testing.T, sc *corev1.SecurityContext) {
+				if *sc.AllowPrivilegeEscalation || *sc.ReadOnlyRootFilesystem {
+					t.Errorf("allowPrivilegeEscalation = %v, readOnlyRootFilesystem = %v", *sc.AllowPrivilegeEscalation, *sc.ReadOnlyRootFilesystem)
+				}
+				if sc.RunAsNonRoot != nil || sc.RunAsUser != nil || sc.Capabilities != nil || sc.SeccompProfile != nil {
+					t.Errorf("unexpected fields set: %+v", sc)
+				}
+			},
+		},
+		{
+			name: "hardened",
+			modify: func(o *sidecar.Options) {
+				o.ReadOnly, o.RunAsNonRoot, o.RunAsUser = true, true, 1000
+				o.DropCapabilities, o.SeccompProfile = []string{"ALL"}, "RuntimeDefault"
+			},
+			check: func(t *testing.T, sc *corev1.SecurityContext) {
+				if !*sc.ReadOnlyRootFilesystem || !*sc.RunAsNonRoot || *sc.RunAsUser != 1000 {
+					t.Errorf("securityContext = %+v", sc)
+				}
+				if !reflect.DeepEqual(sc.Capabilities.Drop, []corev1.Capability{"ALL"}) {
+					t.Errorf("drop = %v", sc.Capabilities.Drop)
+				}
+				if sc.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+					t.Errorf("seccompProfile = %v", sc.SeccompProfile.Type)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := *sidecar.NewSidecarOptions()
+			tt.modify(&options)
+			tt.check(t, NewContainer(options).(*container).securityContext())
+		})
+	}
+}
+
+func TestProbeAndLifecycle(t *testing.T) {
+	tests := []struct {
+		name      string
+		probes    bool
+		preStop   []string
+		wantProbe bool
+	}{
+		{"disabled", false, nil, false},
+		{"enabled", true, []string{"sleep", "5"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := *sidecar.NewSidecarOptions()
+			options.Probes, options.PreStopCommand = tt.probes, tt.preStop
+			options.ProbePeriodSeconds, options.ProbeFailureThreshold = 7, 2
+			c, err := NewContainer(options).Create()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantProbe {
+				if c.ReadinessProbe != nil || c.LivenessProbe != nil {
+					t.Errorf("probes set while disabled")
+				}
+			} else {
+				if c.ReadinessProbe.HTTPGet.Path != "/" || c.LivenessProbe.HTTPGet.Path != HealthPath {
+					t.Errorf("probe paths = %s, %s", c.ReadinessProbe.HTTPGet.Path, c.LivenessProbe.HTTPGet.Path)
+				}
+				if c.LivenessProbe.HTTPGet.Port.IntValue() != HTTPPort || c.LivenessProbe.PeriodSeconds != 7 || c.LivenessProbe.FailureThreshold != 2 {
+					t.Errorf("liveness probe = %+v", c.LivenessProbe)
+				}
+			}
+			if len(tt.preStop) == 0 {
+				if c.Lifecycle != nil {
+					t.Errorf("lifecycle = %+v, want nil", c.Lifecycle)
+				}
+			} else if !reflect.DeepEqual(c.Lifecycle.PreStop.Exec.Command, tt.preStop) {
+				t.Errorf("preStop = %v, want %v", c.Lifecycle.PreStop.Exec.Command, tt.preStop)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	"k8s.io/apimachinery/pkg/util/version"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	lg "kube-sidecar/pkg/clientset/logging"
+)
+
+// 原生sidecar模式
+const (
+	NativeAuto     = "auto"
+	NativeEnabled  = "true"
+	NativeDisabled = "false"
+)
+
+// nativeMinVersion SidecarContainers特性默认开启的最低kubernetes版本
+var nativeMinVersion = version.MustParseGeneric("1.29.0")
+
+// ResolveNative 解析原生sidecar模式,auto时通过discovery检测集群是否支持初始化容器restartPolicy Always。
+// auto只检查kube-apiserver版本,kubelet版本低于1.29或关闭了SidecarContainers特性时kubelet忽略restartPolicy,
+// sidecar会成为永不退出的初始化容器,pod一直处于Init状态,因此默认关闭,确认所有节点支持后再开启
+func ResolveNative(mode string, client kubernetes.Client) string {
+	if mode != NativeAuto {
+		return mode
+	}
+	if client == nil || client.Discovery() == nil {
+		return NativeDisabled
+	}
+	info, err := client.Discovery().ServerVersion()
+	if err != nil {
+		lg.Logger.Warn("获取kubernetes版本失败,不使用原生sidecar,错误信息" + err.Error())
+		return NativeDisabled
+	}
+	v, err := version.ParseGeneric(info.GitVersion)
+	if err != nil || !v.AtLeast(nativeMinVersion) {
+		return NativeDisabled
+	}
+	return NativeEnabled
+}
+
+// SetRestartPolicyAlways 将pod模版中指定名称的初始化容器设置为restartPolicy Always,
+// 当前client-go版本的Container结构体没有restartPolicy字段,需要在序列化后的对象上设置。
+// 使用该版本结构体读取后再整体更新工作负载会丢失该字段,sidecar退化为普通初始化容器,
+// 其他写入方需要使用patch或同样在序列化后的对象上设置
+func SetRestartPolicyAlways(obj map[string]interface{}, name string) bool {
+	// CronJob的pod模版位于spec.jobTemplate.spec.template.spec
+	for _, path := range [][]string{
+		{"spec", "template", "spec"},
+		{"spec", "jobTemplate", "spec", "template", "spec"},
+	} {
+		spec := obj
+		for _, key := range path {
+			next, ok := spec[key].(map[string]interface{})
+			if !ok {
+				spec = nil
+				break
+			}
+			spec = next
+		}
+		initContainers, _ := spec["initContainers"].([]interface{})
+		for _, c := range initContainers {
+			if m, ok := c.(map[string]interface{}); ok && m["name"] == name {
+				m["restartPolicy"] = "Always"
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveNative(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		version string
+		want    string
+	}{
+		{"explicit enabled", NativeEnabled, "", NativeEnabled},
+		{"explicit disabled", NativeDisabled, "v1.30.0", NativeDisabled},
+		{"auto supported", NativeAuto, "v1.29.2", NativeEnabled},
+		{"auto supported with suffix", NativeAuto, "v1.30.1-eks-1234", NativeEnabled},
+		{"auto too old", NativeAuto, "v1.28.5", NativeDisabled},
+		{"auto unknown version", NativeAuto, "unknown", NativeDisabled},
+		{"auto discovery error", NativeAuto, "", NativeDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.version == "" {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"gitVersion":"` + tt.version + `"}`))
+			}))
+			defer server.Close()
+			client := kubernetes.NewFakeClientSets(nil, discovery.NewDiscoveryClientForConfigOrDie(&rest.Config{Host: server.URL}), nil, "", nil)
+			if got := ResolveNative(tt.mode, client); got != tt.want {
+				t.Errorf("ResolveNative(%q) with %q = %q, want %q", tt.mode, tt.version, got, tt.want)
+			}
+		})
+	}
+	if got := ResolveNative(NativeAuto, kubernetes.NewFakeClientSets(nil, nil, nil, "", nil)); got != NativeDisabled {
+		t.Errorf("ResolveNative without discovery = %q", got)
+	}
+}
+
+func TestSetRestartPolicyAlways(t *testing.T) {
+	podSpec := func() map[string]interface{} {
+		return map[string]interface{}{
+			"initContainers": []interface{}{
+				map[string]interface{}{"name": "init"},
+				map[string]interface{}{"name": "sidecar"},
+			},
+		}
+	}
+	tests := []struct {
+		name string
+		obj  map[string]interface{}
+		path []string
+		want bool
+	}{
+		{"deployment", map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"spec": podSpec()}}},
+			[]string{"spec", "template", "spec"}, true},
+		{"cronjob", map[string]interface{}{"spec": map[string]interface{}{"jobTemplate": map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"spec": podSpec()}}}}},
+			[]string{"spec", "jobTemplate", "spec", "template", "spec"}, true},
+		{"no init containers", map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{}}}},
+			nil, false},
+		{"no pod spec", map[string]interface{}{"kind": "ConfigMap"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SetRestartPolicyAlways(tt.obj, "sidecar"); got != tt.want {
+				t.Fatalf("SetRestartPolicyAlways = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			spec := tt.obj
+			for _, key := range tt.path {
+				spec = spec[key].(map[string]interface{})
+			}
+			containers := spec["initContainers"].([]interface{})
+			if containers[1].(map[string]interface{})["restartPolicy"] != "Always" {
+				t.Errorf("sidecar restartPolicy not set: %v", containers)
+			}
+			if _, ok := containers[0].(map[string]interface{})["restartPolicy"]; ok {
+				t.Errorf("other init container modified: %v", containers)
+			}
+		})
+	}
+}
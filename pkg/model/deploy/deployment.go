@@ -18,96 +18,358 @@ package deploy
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"kube-sidecar/pkg/clientset/controller"
 	"kube-sidecar/pkg/clientset/fluent"
 	"kube-sidecar/pkg/clientset/kubernetes"
 	"kube-sidecar/pkg/clientset/logging"
 	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/clientset/tracing"
+	"kube-sidecar/pkg/model/event"
 	"kube-sidecar/pkg/model/secret"
-	"strconv"
+	"sort"
 	"strings"
-	"time"
 
 	"kube-sidecar/pkg/model/container"
+	"kube-sidecar/pkg/model/volume"
 	"kube-sidecar/utils/tools"
 )
 
+// AnnotationSidecar 工作负载开启sidecar注入的注释
+const AnnotationSidecar = "deployment.kubernetes.io/sidecar"
+
+// 事件原因
+const (
+	// ReasonDryRun dry-run模式下记录将要执行的修改
+	ReasonDryRun = "DryRun"
+	// ReasonInvalidAnnotation 工作负载注释配置无效
+	ReasonInvalidAnnotation = "InvalidAnnotation"
+	// ReasonSidecarInjected 成功注入sidecar容器
+	ReasonSidecarInjected = "SidecarInjected"
+	// ReasonSecretCreated 成功创建或更新fluentBit secret
+	ReasonSecretCreated = "SecretCreated"
+	// ReasonInjectionFailed 注入sidecar、写入secret或更新工作负载失败
+	ReasonInjectionFailed = "InjectionFailed"
+	// ReasonExcludedByPolicy 工作负载开启了注入但被白名单排除
+	ReasonExcludedByPolicy = "ExcludedByPolicy"
+)
+
 type deploy struct {
-	k8sClient kubernetes.Client
-	fluentBit fluent.Options
-	sidecar   sidecar.Options
+	k8sClient  kubernetes.Client
+	fluentBit  fluent.Options
+	sidecar    sidecar.Options
+	controller controller.Options
 }
 
 type Deploy interface {
-	AddSidecar(deployment *appsv1.Deployment) error
+	AddSidecar(ctx context.Context, deployment *appsv1.Deployment) error
+	Inject(meta *metav1.ObjectMeta, spec *corev1.PodSpec) (*corev1.Secret, []string, error)
+	RecordStatus(ctx context.Context, deployment *appsv1.Deployment, status, message string) error
 }
 
-func NewDeploy(k8sClient kubernetes.Client, fluentBit fluent.Options) Deploy {
+func NewDeploy(k8sClient kubernetes.Client, fluentBit fluent.Options, sidecar sidecar.Options, controller controller.Options) Deploy {
 	return &deploy{
-		k8sClient: k8sClient,
-		fluentBit: fluentBit,
+		k8sClient:  k8sClient,
+		fluentBit:  fluentBit,
+		sidecar:    sidecar,
+		controller: controller,
 	}
 }
 
 // AddSidecar 为deployment添加sidecar容器方法
-func (d *deploy) AddSidecar(deployment *appsv1.Deployment) error {
-	// 定义全局错误信息
-	var (
-		errMsg error
-	)
-	// 创建sidecar容器对象
-	s := container.NewContainer(d.sidecar).Create()
-	// 增加sidecar容器到deployment
-	deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, *s)
-	// 获取sidecar 后端存储类型
-	interval, _ := strconv.Atoi(tools.SetDefaultValueNotExist(
-		deployment.Annotations["deployment.kubernetes.io/sidecar.inputRefreshInterval"],
-		string(rune(d.fluentBit.InputRefreshInterval))))
+func (d *deploy) AddSidecar(ctx context.Context, deployment *appsv1.Deployment) error {
+	log := logging.FromContext(ctx)
+	// 保留注入前的元数据,用于记录失败状态
+	before := &appsv1.Deployment{ObjectMeta: *deployment.ObjectMeta.DeepCopy()}
+	// 注入sidecar容器与卷,生成fluentBit secret
+	_, span := tracing.Start(ctx, "render",
+		attribute.String("backend", deployment.Annotations["deployment.kubernetes.io/sidecar.backend"]))
+	newSecret, warnings, err := d.Inject(&deployment.ObjectMeta, &deployment.Spec.Template.Spec)
+	tracing.End(span, err)
+	for _, warning := range warnings {
+		log.Warn("注解配置无效", zap.String("warning", warning))
+		event.Recorder.Event(deployment, corev1.EventTypeWarning, ReasonInvalidAnnotation, warning)
+	}
+	if err != nil {
+		log.Error("注入sidecar失败", zap.Error(err))
+		event.Recorder.Event(deployment, corev1.EventTypeWarning, ReasonInjectionFailed, "注入sidecar失败: "+err.Error())
+		d.recordFailed(ctx, before, err)
+		return err
+	}
+	// 创建fluentBit secret,写入失败时不更新deployment,否则pod引用不存在的secret无法启动,由工作队列重试
+	secretCtx, span := tracing.Start(ctx, "secret.apply", attribute.String("secret", newSecret.Name))
+	err = secret.NewSecret(d.k8sClient, d.controller).Apply(secretCtx, newSecret)
+	tracing.End(span, err)
+	if err != nil {
+		log.Error("写入fluentBit secret失败", zap.String("secret", newSecret.Name), zap.Error(err))
+		event.Recorder.Event(deployment, corev1.EventTypeWarning, ReasonInjectionFailed, "写入fluentBit secret "+newSecret.Name+"失败: "+err.Error())
+		d.recordFailed(ctx, before, err)
+		return err
+	}
+	if !d.controller.DryRun {
+		event.Recorder.Event(deployment, corev1.EventTypeNormal, ReasonSecretCreated, "已创建或更新fluentBit secret "+newSecret.Name)
+	}
+	// 在同一次更新中写入注入状态注释
+	setInjectedStatus(&deployment.ObjectMeta, &deployment.Spec.Template.Spec, d.sidecar.Name, newSecret)
+	// 更新Deployment object添加新的sidecar容器和卷,dry-run模式下只在服务端校验
+	options := metav1.UpdateOptions{}
+	if d.controller.DryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	updateCtx, span := tracing.Start(ctx, "workload.update")
+	err = d.update(updateCtx, deployment, options)
+	tracing.End(span, err)
+	if err != nil {
+		log.Error("更新deployment失败", zap.Error(err))
+		event.Recorder.Event(deployment, corev1.EventTypeWarning, ReasonInjectionFailed, "更新deployment失败: "+err.Error())
+		d.recordFailed(ctx, before, err)
+		return err
+	}
+	if d.controller.DryRun {
+		message := dryRunMessage(deployment.Annotations, newSecret.Name)
+		log.Info(message, zap.Bool("dryRun", true))
+		event.Recorder.Event(deployment, corev1.EventTypeNormal, ReasonDryRun, message)
+		return nil
+	}
+	log.Info("注入sidecar成功", zap.String("image", d.sidecar.Image))
+	event.Recorder.Event(deployment, corev1.EventTypeNormal, ReasonSidecarInjected, "已注入sidecar容器"+d.sidecar.Name+",镜像"+d.sidecar.Image)
+	return nil
+}
+
+// recordFailed 记录注入失败状态,写入失败时只记录日志
+func (d *deploy) recordFailed(ctx context.Context, deployment *appsv1.Deployment, cause error) {
+	if err := d.RecordStatus(ctx, deployment, StatusFailed, cause.Error()); err != nil {
+		logging.FromContext(ctx).Error("记录注入状态失败", zap.Error(err))
+	}
+}
+
+// update 更新Deployment,原生sidecar模式下需要在序列化后的对象上设置初始化容器的restartPolicy,
+// 通过原始JSON整体PUT,这是唯一写入该字段的地方
+func (d *deploy) update(ctx context.Context, deployment *appsv1.Deployment, options metav1.UpdateOptions) error {
+	deployments := d.k8sClient.Kubernetes().AppsV1().Deployments(deployment.Namespace)
+	if d.sidecar.NativeSidecar != container.NativeEnabled {
+		_, err := deployments.Update(ctx, deployment, options)
+		return err
+	}
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return err
+	}
+	var obj map[string]interface{}
+	if err = json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	obj["apiVersion"], obj["kind"] = "apps/v1", "Deployment"
+	container.SetRestartPolicyAlways(obj, d.sidecar.Name)
+	if data, err = json.Marshal(obj); err != nil {
+		return err
+	}
+	return d.k8sClient.Kubernetes().AppsV1().RESTClient().Put().
+		Namespace(deployment.Namespace).
+		Resource("deployments").
+		Name(deployment.Name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Error()
+}
+
+// dryRunMessage 根据注入标记描述dry-run模式下将要执行的修改
+func dryRunMessage(annotations map[string]string, secretName string) string {
+	marker, _, _ := ParseMarker(annotations)
+	message := "dry-run模式,未实际修改: 将注入sidecar容器" + marker.Container
+	if len(marker.Volumes) > 0 {
+		message += ",新增卷" + strings.Join(marker.Volumes, "、")
+	}
+	for _, name := range sortedKeys(marker.Mounts) {
+		message += ",为容器" + name + "挂载" + strings.Join(marker.Mounts[name], "、")
+	}
+	return message + ",创建或更新secret " + secretName
+}
+
+// Inject 根据工作负载的注释为pod模版注入sidecar容器与卷,并在工作负载注释中记录注入标记,
+// 返回需要创建的fluentBit secret与注释配置的警告信息,不调用kubernetes接口
+func (d *deploy) Inject(meta *metav1.ObjectMeta, target *corev1.PodSpec) (*corev1.Secret, []string, error) {
+	annotations := meta.Annotations
+	// 在副本上注入,失败时不修改原始pod模版
+	spec := target.DeepCopy()
+	// 使用工作负载注释覆盖资源配置后创建sidecar容器对象
+	options, warnings := d.resources(annotations)
+	s, err := container.NewContainer(options).Create()
+	if err != nil {
+		return nil, warnings, err
+	}
+	// 获取应用日志路径
+	logPath := tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.inputLogPath"], d.fluentBit.InputLogPath)
+	// 在应用容器与sidecar容器之间注入共享日志卷
+	err = volume.NewVolume(d.sidecar).SharedLog(
+		spec,
+		s,
+		logPath,
+		tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.logVolumeSizeLimit"], d.sidecar.LogVolumeSizeLimit),
+		tools.SplitNotEmpty(annotations["deployment.kubernetes.io/sidecar.logContainers"], ","))
+	if err != nil {
+		return nil, warnings, fmt.Errorf("注入共享日志卷失败: %w", err)
+	}
+	// 注入fluentBit位置数据库与文件缓冲卷
+	storagePath := tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.storagePath"], d.fluentBit.StoragePath)
+	err = volume.NewVolume(d.sidecar).Storage(
+		spec,
+		s,
+		storagePath,
+		tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.storageVolumeSizeLimit"], d.sidecar.StorageVolumeSizeLimit))
+	if err != nil {
+		return nil, warnings, fmt.Errorf("注入fluentBit存储卷失败: %w", err)
+	}
+	// 批处理工作负载注入完成模式,应用容器退出后sidecar刷新日志并退出
+	if d.completion(annotations, spec) {
+		warnings = append(warnings, container.Completion(
+			spec,
+			s,
+			d.sidecar,
+			tools.SplitNotEmpty(annotations["deployment.kubernetes.io/sidecar.logContainers"], ","),
+			annotations["deployment.kubernetes.io/sidecar.completionWrap"] != "false")...)
+	}
+	// 获取采集刷新间隔与内存chunk上限,注释值无效时使用全局配置
+	interval, invalid := positiveInt(annotations, "deployment.kubernetes.io/sidecar.inputRefreshInterval", d.fluentBit.InputRefreshInterval)
+	warnings = append(warnings, invalid...)
+	maxChunksUp, invalid := positiveInt(annotations, "deployment.kubernetes.io/sidecar.storageMaxChunksUp", d.fluentBit.StorageMaxChunksUp)
+	warnings = append(warnings, invalid...)
+	// 合并全局默认与工作负载注释中配置的FILTER流水线
+	filters, err := d.filters(meta.Name, meta.Namespace, annotations)
+	if err != nil {
+		return nil, warnings, fmt.Errorf("解析filter配置失败: %w", err)
+	}
 	f := fluent.Options{
-		ServiceLogLevel: tools.SetDefaultValueNotExist(deployment.Annotations["deployment.kubernetes.io/sidecar.serviceLogLevel"], d.fluentBit.ServiceLogLevel),
-		InputAppName:    deployment.Name,
-		InputLogPath:    tools.SetDefaultValueNotExist(deployment.Annotations["deployment.kubernetes.io/sidecar.inputLogPath"], "/tmp"),
-		// InputAppTag:  deployment.Name,
-		InputMemBufLimit:     tools.SetDefaultValueNotExist(deployment.Annotations["deployment.kubernetes.io/sidecar.inputMemBufLimit"], d.fluentBit.InputMemBufLimit),
-		InputRefreshInterval: interval,
-		OutputEsHost:         deployment.Annotations["deployment.kubernetes.io/sidecar.outputEsHost"],
-		OutputEsPort:         tools.SetDefaultValueNotExist(deployment.Annotations["deployment.kubernetes.io/sidecar.outputEsPort"], "9200"),
-		OutputEsIndex:        tools.SetDefaultValueNotExist(deployment.Annotations["deployment.kubernetes.io/sidecar.outputEsIndex"], deployment.Name+time.Now().Format("2006-01-02")),
-		OutputEsUser:         deployment.Annotations["deployment.kubernetes.io/sidecar.outputEsUser"],
-		OutputEsPassword:     deployment.Annotations["deployment.kubernetes.io/sidecar.outputEsPassword"],
-		OutputKafkaHost:      deployment.Annotations["deployment.kubernetes.io/sidecar.outputKafkaHost"],
-		OutputKafkaPort:      tools.SetDefaultValueNotExist(deployment.Annotations["deployment.kubernetes.io/sidecar.outputKafkaPort"], "9092"),
-		OutputKafkaTopic:     deployment.Annotations["deployment.kubernetes.io/sidecar.outputKafkaTopic"],
-		OutputKafkaUser:      deployment.Annotations["deployment.kubernetes.io/sidecar.outputKafkaUser"],
-		OutputKafkaPassword:  deployment.Annotations["deployment.kubernetes.io/sidecar.outputKafkaPassword"],
+		ServiceLogLevel: tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.serviceLogLevel"], d.fluentBit.ServiceLogLevel),
+		ServiceGrace:    d.fluentBit.ServiceGrace,
+		InputAppName:    meta.Name,
+		InputLogPath:    logPath,
+		// InputAppTag:  meta.Name,
+		InputMemBufLimit:       tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.inputMemBufLimit"], d.fluentBit.InputMemBufLimit),
+		InputRefreshInterval:   interval,
+		OutputEsHost:           annotations["deployment.kubernetes.io/sidecar.outputEsHost"],
+		OutputEsPort:           tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.outputEsPort"], "9200"),
+		OutputEsIndex:          annotations["deployment.kubernetes.io/sidecar.outputEsIndex"],
+		OutputEsUser:           annotations["deployment.kubernetes.io/sidecar.outputEsUser"],
+		OutputEsPassword:       annotations["deployment.kubernetes.io/sidecar.outputEsPassword"],
+		OutputKafkaHost:        annotations["deployment.kubernetes.io/sidecar.outputKafkaHost"],
+		OutputKafkaPort:        tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.outputKafkaPort"], "9092"),
+		OutputKafkaTopic:       annotations["deployment.kubernetes.io/sidecar.outputKafkaTopic"],
+		OutputKafkaUser:        annotations["deployment.kubernetes.io/sidecar.outputKafkaUser"],
+		OutputKafkaPassword:    annotations["deployment.kubernetes.io/sidecar.outputKafkaPassword"],
+		StoragePath:            storagePath,
+		StorageType:            tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.storageType"], d.fluentBit.StorageType),
+		StorageBacklogMemLimit: tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.storageBacklogMemLimit"], d.fluentBit.StorageBacklogMemLimit),
+		StorageMaxChunksUp:     maxChunksUp,
+		StorageTotalLimitSize:  tools.SetDefaultValueNotExist(annotations["deployment.kubernetes.io/sidecar.storageTotalLimitSize"], d.fluentBit.StorageTotalLimitSize),
+		Filters:                filters,
 	}
 	// 获取fluentBit output类型
-	backendType := deployment.Annotations["deployment.kubernetes.io/sidecar.backend"]
-	// 基于backendType创建不同的secret配置
-	err := secret.NewSecret(d.k8sClient).FluentBit(backendType, deployment.Name, deployment.Namespace, f)
+	backendType := annotations["deployment.kubernetes.io/sidecar.backend"]
+	// 基于backendType生成不同的secret配置
+	newSecret, err := secret.Build(backendType, meta.Name, meta.Namespace, f)
 	if err != nil {
-		logging.Logger.Error(err.Error())
-		errMsg = err
+		return nil, warnings, err
 	}
-	// 创建secret volume对象
-	secretVolume := corev1.Volume{
+	// 增加sidecar容器到pod模版,原生sidecar模式下作为初始化容器注入
+	if d.sidecar.NativeSidecar == container.NativeEnabled {
+		spec.InitContainers = append(spec.InitContainers, *s)
+	} else {
+		spec.Containers = append(spec.Containers, *s)
+	}
+	// 保证pod优雅停止时间足够fluentBit刷新缓冲数据
+	if grace := d.sidecar.TerminationGracePeriodSeconds; grace > 0 {
+		current := int64(corev1.DefaultTerminationGracePeriodSeconds)
+		if spec.TerminationGracePeriodSeconds != nil {
+			current = *spec.TerminationGracePeriodSeconds
+		}
+		if current < grace {
+			spec.TerminationGracePeriodSeconds = &grace
+		}
+	}
+	// 添加secret卷至pod模版
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
 		Name: d.sidecar.VolumeName,
 		VolumeSource: corev1.VolumeSource{
 			Secret: &corev1.SecretVolumeSource{
-				SecretName: strings.Join([]string{deployment.Name, "sidecar"}, "-"),
+				SecretName: newSecret.Name,
 			},
 		},
+	})
+	// 记录注入标记,复制注释避免失败时修改原始对象
+	annotations = make(map[string]string, len(meta.Annotations)+len(MarkerAnnotations))
+	for k, v := range meta.Annotations {
+		annotations[k] = v
 	}
-	// 添加卷至Deployment
-	deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, secretVolume)
-	// 更新Deployment object添加新的sidecar容器和卷
-	_, err = d.k8sClient.Kubernetes().AppsV1().Deployments(deployment.Namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
-	if err != nil {
-		logging.Logger.Error("更新deployment " + deployment.Name + "失败,错误信息," + err.Error())
-		errMsg = err
+	for k, v := range NewMarker(target, spec, s.Name, newSecret.Name).Annotations() {
+		annotations[k] = v
+	}
+	meta.Annotations = annotations
+	*target = *spec
+	return newSecret, warnings, nil
+}
+
+// completion 判断是否注入完成模式,注释未设置时restartPolicy为Never或OnFailure的批处理pod开启;
+// 原生sidecar由kubelet在应用容器退出后停止,不需要完成模式
+func (d *deploy) completion(annotations map[string]string, spec *corev1.PodSpec) bool {
+	if d.sidecar.NativeSidecar == container.NativeEnabled {
+		return false
+	}
+	switch annotations["deployment.kubernetes.io/sidecar.completion"] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return spec.RestartPolicy == corev1.RestartPolicyNever || spec.RestartPolicy == corev1.RestartPolicyOnFailure
+}
+
+// filters 合并全局默认与工作负载注释中配置的FILTER,注释sidecar.defaultFilters为false时不使用全局默认配置
+// 默认在流水线最前面追加kubernetes元数据,注释sidecar.kubernetesMetadata为false时关闭
+func (d *deploy) filters(name, namespace string, annotations map[string]string) ([]fluent.Filter, error) {
+	var filters []fluent.Filter
+	if annotations["deployment.kubernetes.io/sidecar.kubernetesMetadata"] != "false" {
+		filters = append(filters, fluent.NewKubernetesMetadataFilter(namespace, name))
+	}
+	if annotations["deployment.kubernetes.io/sidecar.defaultFilters"] != "false" {
+		filters = append(filters, d.fluentBit.Filters...)
+	}
+	if value := annotations["deployment.kubernetes.io/sidecar.filters"]; value != "" {
+		var custom []fluent.Filter
+		if err := json.Unmarshal([]byte(value), &custom); err != nil {
+			return nil, err
+		}
+		filters = append(filters, custom...)
+	}
+	// 开启脱敏时在流水线最后追加脱敏filter,注释sidecar.maskRules可覆盖启用的内置规则
+	if annotations["deployment.kubernetes.io/sidecar.mask"] == "true" {
+		names := d.fluentBit.MaskRules
+		if value, ok := annotations["deployment.kubernetes.io/sidecar.maskRules"]; ok {
+			names = tools.SplitNotEmpty(value, ",")
+		}
+		rules, err := fluent.MaskRules(names, d.fluentBit.MaskCustomRules)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, fluent.NewMaskFilter(rules))
+	}
+	return filters, nil
+}
+
+// sortedKeys 获取排序后的map key
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	logging.Logger.Info("更新deployment " + deployment.Name + "成功!")
-	return errMsg
+	sort.Strings(keys)
+	return keys
 }
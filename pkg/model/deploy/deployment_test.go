@@ -0,0 +1,285 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deploy
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/fluent"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/model/container"
+	"kube-sidecar/pkg/model/event"
+	"kube-sidecar/pkg/model/secret"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddSidecarInjectionFailed(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	recorder := record.NewFakeRecorder(10)
+	event.Recorder = recorder
+	defer func() { event.Recorder = &record.FakeRecorder{} }()
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(spans)))
+	defer otel.SetTracerProvider(tracesdk.NewTracerProvider())
+	dp := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnotationSidecar: "true", "deployment.kubernetes.io/sidecar.backend": "unknown"},
+		},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "nginx"}},
+		}}},
+	}
+	clientset := fake.NewSimpleClientset(dp.DeepCopy())
+	client := kubernetes.NewFakeClientSets(clientset, nil, nil, "", nil)
+	d := NewDeploy(client, *fluent.NewFluentBitOptions(), *sidecar.NewSidecarOptions(), *controller.NewControllerOptions())
+	if err := d.AddSidecar(context.TODO(), dp); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+	// 渲染失败记录在render span上
+	ended := spans.Ended()
+	if len(ended) != 1 || ended[0].Name() != "render" || ended[0].Status().Code != codes.Error {
+		t.Errorf("spans = %v", ended)
+	}
+	// 注入失败状态写入工作负载注释
+	current, err := clientset.AppsV1().Deployments("default").Get(context.TODO(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Annotations[AnnotationStatus] != StatusFailed || current.Annotations[AnnotationLastError] == "" {
+		t.Errorf("status annotations = %v", current.Annotations)
+	}
+	select {
+	case e := <-recorder.Events:
+		if !strings.HasPrefix(e, corev1.EventTypeWarning+" "+ReasonInjectionFailed) {
+			t.Errorf("event = %q", e)
+		}
+	default:
+		t.Fatal("no event recorded")
+	}
+}
+
+func TestAddSidecarSecretFailed(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	dp := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "default",
+			Annotations: map[string]string{
+				AnnotationSidecar:                               "true",
+				"deployment.kubernetes.io/sidecar.backend":      "elasticsearch",
+				"deployment.kubernetes.io/sidecar.outputEsHost": "es",
+			},
+		},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "nginx"}},
+		}}},
+	}
+	clientset := fake.NewSimpleClientset(dp.DeepCopy())
+	clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("secrets is forbidden")
+	})
+	client := kubernetes.NewFakeClientSets(clientset, nil, nil, "", nil)
+	d := NewDeploy(client, *fluent.NewFluentBitOptions(), *sidecar.NewSidecarOptions(), *controller.NewControllerOptions())
+	if err := d.AddSidecar(context.TODO(), dp); err == nil {
+		t.Fatal("expected error when the secret cannot be written")
+	}
+	// secret写入失败时不注入sidecar,下次处理时重试
+	current, err := clientset.AppsV1().Deployments("default").Get(context.TODO(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current.Spec.Template.Spec.Containers) != 1 || len(current.Spec.Template.Spec.Volumes) != 0 {
+		t.Errorf("deployment was injected: %v", current.Spec.Template.Spec)
+	}
+	if current.Annotations[AnnotationStatus] != StatusFailed || !strings.Contains(current.Annotations[AnnotationLastError], "forbidden") {
+		t.Errorf("status annotations = %v", current.Annotations)
+	}
+	if Injected(current.Spec.Template.Spec, "sidecar") {
+		t.Error("expected deployment to be retried on next reconcile")
+	}
+}
+
+func TestInjectNativeSidecar(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	tests := []struct {
+		name       string
+		native     string
+		init       bool
+		completion bool
+	}{
+		{"native", container.NativeEnabled, true, false},
+		{"regular", container.NativeDisabled, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := *sidecar.NewSidecarOptions()
+			options.NativeSidecar = tt.native
+			meta := metav1.ObjectMeta{
+				Name:      "report",
+				Namespace: "default",
+				Annotations: map[string]string{
+					"deployment.kubernetes.io/sidecar.backend":      "elasticsearch",
+					"deployment.kubernetes.io/sidecar.outputEsHost": "es",
+				},
+			}
+			spec := corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				Containers:    []corev1.Container{{Name: "app", Image: "busybox", Command: []string{"report"}}},
+			}
+			d := NewDeploy(kubernetes.NewFakeClientSets(nil, nil, nil, "", nil), *fluent.NewFluentBitOptions(), options, *controller.NewControllerOptions())
+			if _, _, err := d.(*deploy).Inject(&meta, &spec); err != nil {
+				t.Fatal(err)
+			}
+			inInit := len(spec.InitContainers) == 1 && spec.InitContainers[0].Name == options.Name
+			if inInit != tt.init || len(spec.Containers)+len(spec.InitContainers) != 2 {
+				t.Errorf("init containers = %v, containers = %v", spec.InitContainers, spec.Containers)
+			}
+			// 原生sidecar由kubelet停止,不注入完成模式
+			wrapped := spec.Containers[0].Command[0] == "/bin/sh"
+			if wrapped != tt.completion {
+				t.Errorf("completion wrap = %v, want %v: %v", wrapped, tt.completion, spec.Containers[0].Command)
+			}
+		})
+	}
+}
+
+func TestInjectRejectsFilterNewlines(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	meta := metav1.ObjectMeta{
+		Name:      "app",
+		Namespace: "default",
+		Annotations: map[string]string{
+			"deployment.kubernetes.io/sidecar.backend":      "elasticsearch",
+			"deployment.kubernetes.io/sidecar.outputEsHost": "es",
+			"deployment.kubernetes.io/sidecar.filters":      `[{"name":"modify","add":["env prod\n[OUTPUT]\n    Name http"]}]`,
+		},
+	}
+	spec := corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}}
+	d := NewDeploy(kubernetes.NewFakeClientSets(nil, nil, nil, "", nil), *fluent.NewFluentBitOptions(), *sidecar.NewSidecarOptions(), *controller.NewControllerOptions())
+	if _, _, err := d.(*deploy).Inject(&meta, &spec); err == nil {
+		t.Fatal("expected filter with newline to be rejected")
+	}
+	if len(spec.Containers) != 1 {
+		t.Errorf("pod template modified: %v", spec.Containers)
+	}
+}
+
+func TestFiltersAnnotations(t *testing.T) {
+	global := fluent.Filter{Name: fluent.FilterGrep, Exclude: []string{"log /healthz"}}
+	custom := fluent.Filter{Name: fluent.FilterModify, Add: []string{"team payments"}}
+	metadata := fluent.NewKubernetesMetadataFilter("default", "app")
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+		wantErr     bool
+	}{
+		{name: "default", want: []string{fluent.FilterRecordModifier, fluent.FilterGrep}},
+		{name: "metadata disabled", annotations: map[string]string{"deployment.kubernetes.io/sidecar.kubernetesMetadata": "false"}, want: []string{fluent.FilterGrep}},
+		{name: "default filters disabled", annotations: map[string]string{"deployment.kubernetes.io/sidecar.defaultFilters": "false"}, want: []string{fluent.FilterRecordModifier}},
+		{name: "custom filters", annotations: map[string]string{"deployment.kubernetes.io/sidecar.filters": `[{"name":"modify","add":["team payments"]}]`}, want: []string{fluent.FilterRecordModifier, fluent.FilterGrep, fluent.FilterModify}},
+		{name: "invalid custom filters", annotations: map[string]string{"deployment.kubernetes.io/sidecar.filters": "grep"}, wantErr: true},
+		{name: "mask last", annotations: map[string]string{"deployment.kubernetes.io/sidecar.mask": "true"}, want: []string{fluent.FilterRecordModifier, fluent.FilterGrep, fluent.FilterLua}},
+		{name: "unknown mask rule", annotations: map[string]string{"deployment.kubernetes.io/sidecar.mask": "true", "deployment.kubernetes.io/sidecar.maskRules": "phone"}, wantErr: true},
+	}
+	options := fluent.NewFluentBitOptions()
+	options.Filters = []fluent.Filter{global}
+	d := NewDeploy(kubernetes.NewFakeClientSets(nil, nil, nil, "", nil), *options, *sidecar.NewSidecarOptions(), *controller.NewControllerOptions()).(*deploy)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := d.filters("app", "default", tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, f := range filters {
+				names = append(names, f.Name)
+				switch f.Name {
+				case fluent.FilterRecordModifier:
+					if !reflect.DeepEqual(f, metadata) {
+						t.Errorf("metadata filter = %+v", f)
+					}
+				case fluent.FilterModify:
+					if !reflect.DeepEqual(f, custom) {
+						t.Errorf("custom filter = %+v", f)
+					}
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("filters = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectKubernetesMetadata(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	meta := metav1.ObjectMeta{
+		Name:      "app",
+		Namespace: "payments",
+		Annotations: map[string]string{
+			"deployment.kubernetes.io/sidecar.backend":      "elasticsearch",
+			"deployment.kubernetes.io/sidecar.outputEsHost": "es",
+		},
+	}
+	spec := corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}}
+	d := NewDeploy(kubernetes.NewFakeClientSets(nil, nil, nil, "", nil), *fluent.NewFluentBitOptions(), *sidecar.NewSidecarOptions(), *controller.NewControllerOptions())
+	newSecret, _, err := d.Inject(&meta, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(newSecret.Data[secret.ConfigKey])
+	for _, want := range []string{"Record k8s.namespace payments", "Record k8s.workload app", "Record k8s.pod ${POD_NAME}", "Record k8s.node ${NODE_NAME}"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("config missing %q:\n%s", want, config)
+		}
+	}
+	// pod与node信息通过Downward API注入sidecar容器
+	env := make(map[string]string)
+	for _, c := range spec.Containers {
+		if c.Name != sidecar.NewSidecarOptions().Name {
+			continue
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom != nil && e.ValueFrom.FieldRef != nil {
+				env[e.Name] = e.ValueFrom.FieldRef.FieldPath
+			}
+		}
+	}
+	want := map[string]string{"POD_NAME": "metadata.name", "POD_NAMESPACE": "metadata.namespace", "NODE_NAME": "spec.nodeName", "POD_IP": "status.podIP"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("sidecar env = %v, want %v", env, want)
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deploy
+
+import (
+	"encoding/json"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kube-sidecar/utils/tools"
+	"strconv"
+	"strings"
+)
+
+// 记录注入内容的标记注释,uninject根据标记注释移除kube-sidecar添加的容器、卷与卷挂载
+const (
+	AnnotationInjectedContainer = "sidecar.kube-sidecar.io/injected-container"
+	AnnotationInjectedVolumes   = "sidecar.kube-sidecar.io/injected-volumes"
+	AnnotationInjectedMounts    = "sidecar.kube-sidecar.io/injected-mounts"
+	AnnotationInjectedSecret    = "sidecar.kube-sidecar.io/injected-secret"
+	// AnnotationOriginalGracePeriod 注入时调大了pod优雅停止时间,记录原始值,原始未设置时为空
+	AnnotationOriginalGracePeriod = "sidecar.kube-sidecar.io/original-grace-period"
+	// AnnotationOriginalCommands 完成模式包装了应用容器命令,记录应用容器名称与原始command、args
+	AnnotationOriginalCommands = "sidecar.kube-sidecar.io/original-commands"
+)
+
+// MarkerAnnotations 所有注入标记注释
+var MarkerAnnotations = []string{
+	AnnotationInjectedContainer,
+	AnnotationInjectedVolumes,
+	AnnotationInjectedMounts,
+	AnnotationInjectedSecret,
+	AnnotationOriginalGracePeriod,
+	AnnotationOriginalCommands,
+}
+
+// Command 容器的原始启动命令
+type Command struct {
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Marker 注入标记,记录kube-sidecar向pod模版添加的内容
+type Marker struct {
+	Container string
+	Volumes   []string
+	// Mounts 应用容器名称与新增的卷挂载路径
+	Mounts map[string][]string
+	Secret string
+	// GracePeriodChanged 是否修改了pod优雅停止时间,GracePeriod为原始值
+	GracePeriodChanged bool
+	GracePeriod        *int64
+	// Commands 被包装了启动命令的应用容器名称与原始命令
+	Commands map[string]Command
+}
+
+// NewMarker 对比注入前后的pod模版生成注入标记,复用的应用已有卷不会被记录
+func NewMarker(before, after *corev1.PodSpec, containerName, secretName string) Marker {
+	m := Marker{
+		Container: containerName,
+		Mounts:    make(map[string][]string),
+		Secret:    secretName,
+	}
+	if !equality.Semantic.DeepEqual(before.TerminationGracePeriodSeconds, after.TerminationGracePeriodSeconds) {
+		m.GracePeriodChanged = true
+		m.GracePeriod = before.TerminationGracePeriodSeconds
+	}
+	existing := make(map[string]bool, len(before.Volumes))
+	for _, v := range before.Volumes {
+		existing[v.Name] = true
+	}
+	for _, v := range after.Volumes {
+		if !existing[v.Name] {
+			m.Volumes = append(m.Volumes, v.Name)
+		}
+	}
+	for _, c := range before.Containers {
+		mounted := make(map[string]bool, len(c.VolumeMounts))
+		for _, vm := range c.VolumeMounts {
+			mounted[vm.MountPath] = true
+		}
+		for _, ac := range after.Containers {
+			if ac.Name != c.Name {
+				continue
+			}
+			if !equality.Semantic.DeepEqual(c.Command, ac.Command) || !equality.Semantic.DeepEqual(c.Args, ac.Args) {
+				if m.Commands == nil {
+					m.Commands = make(map[string]Command)
+				}
+				m.Commands[c.Name] = Command{Command: c.Command, Args: c.Args}
+			}
+			for _, vm := range ac.VolumeMounts {
+				if !mounted[vm.MountPath] {
+					m.Mounts[c.Name] = append(m.Mounts[c.Name], vm.MountPath)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// Annotations 将注入标记转换为注释
+func (m Marker) Annotations() map[string]string {
+	mounts, _ := json.Marshal(m.Mounts)
+	annotations := map[string]string{
+		AnnotationInjectedContainer: m.Container,
+		AnnotationInjectedVolumes:   strings.Join(m.Volumes, ","),
+		AnnotationInjectedMounts:    string(mounts),
+		AnnotationInjectedSecret:    m.Secret,
+	}
+	if m.GracePeriodChanged {
+		annotations[AnnotationOriginalGracePeriod] = ""
+		if m.GracePeriod != nil {
+			annotations[AnnotationOriginalGracePeriod] = strconv.FormatInt(*m.GracePeriod, 10)
+		}
+	}
+	if len(m.Commands) > 0 {
+		commands, _ := json.Marshal(m.Commands)
+		annotations[AnnotationOriginalCommands] = string(commands)
+	}
+	return annotations
+}
+
+// ParseMarker 从注释中解析注入标记,工作负载没有注入标记时返回false
+func ParseMarker(annotations map[string]string) (Marker, bool, error) {
+	m := Marker{
+		Container: annotations[AnnotationInjectedContainer],
+		Secret:    annotations[AnnotationInjectedSecret],
+	}
+	if m.Container == "" {
+		return m, false, nil
+	}
+	m.Volumes = tools.SplitNotEmpty(annotations[AnnotationInjectedVolumes], ",")
+	if value, changed := annotations[AnnotationOriginalGracePeriod]; changed {
+		m.GracePeriodChanged = true
+		if value != "" {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return m, true, err
+			}
+			m.GracePeriod = &seconds
+		}
+	}
+	if value := annotations[AnnotationInjectedMounts]; value != "" {
+		if err := json.Unmarshal([]byte(value), &m.Mounts); err != nil {
+			return m, true, err
+		}
+	}
+	if value := annotations[AnnotationOriginalCommands]; value != "" {
+		if err := json.Unmarshal([]byte(value), &m.Commands); err != nil {
+			return m, true, err
+		}
+	}
+	return m, true, nil
+}
+
+// Uninject 根据注入标记移除kube-sidecar添加的容器、卷、卷挂载与标记注释并恢复被包装的启动命令,
+// 没有注入标记时只移除名称为sidecarName的容器,返回是否修改了工作负载
+func Uninject(meta *metav1.ObjectMeta, spec *corev1.PodSpec, sidecarName string) (bool, error) {
+	marker, ok, err := ParseMarker(meta.Annotations)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		marker = Marker{Container: sidecarName}
+	}
+	changed := false
+	var containers []corev1.Container
+	for _, c := range spec.Containers {
+		if c.Name == marker.Container {
+			changed = true
+			continue
+		}
+		var mounts []corev1.VolumeMount
+		for _, vm := range c.VolumeMounts {
+			if tools.WhetherExists(vm.MountPath, marker.Mounts[c.Name]) {
+				changed = true
+				continue
+			}
+			mounts = append(mounts, vm)
+		}
+		c.VolumeMounts = mounts
+		if command, wrapped := marker.Commands[c.Name]; wrapped {
+			c.Command, c.Args = command.Command, command.Args
+			changed = true
+		}
+		containers = append(containers, c)
+	}
+	spec.Containers = containers
+	var initContainers []corev1.Container
+	for _, c := range spec.InitContainers {
+		if c.Name == marker.Container {
+			changed = true
+			continue
+		}
+		initContainers = append(initContainers, c)
+	}
+	spec.InitContainers = initContainers
+	var volumes []corev1.Volume
+	for _, v := range spec.Volumes {
+		if tools.WhetherExists(v.Name, marker.Volumes) {
+			changed = true
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	spec.Volumes = volumes
+	if marker.GracePeriodChanged {
+		spec.TerminationGracePeriodSeconds = marker.GracePeriod
+		changed = true
+	}
+	if ok {
+		annotations := make(map[string]string, len(meta.Annotations))
+		for k, v := range meta.Annotations {
+			if !tools.WhetherExists(k, MarkerAnnotations) && !tools.WhetherExists(k, StatusAnnotations) {
+				annotations[k] = v
+			}
+		}
+		meta.Annotations = annotations
+		changed = true
+	}
+	return changed, nil
+}
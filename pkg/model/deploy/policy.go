@@ -0,0 +1,61 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deploy
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kube-sidecar/pkg/clientset/workload"
+	"kube-sidecar/utils/tools"
+)
+
+// 工作负载不注入sidecar的原因
+const (
+	ReasonNotAnnotated       = "NotAnnotated"
+	ReasonNamespaceWhiteList = "NamespaceWhiteList"
+	ReasonWorkloadWhiteList  = "WorkloadWhiteList"
+	ReasonAlreadyInjected    = "AlreadyInjected"
+)
+
+// ExcludedReason 检查工作负载是否需要注入sidecar,需要注入时返回空字符串,否则返回不注入的原因
+func ExcludedReason(meta metav1.ObjectMeta, spec corev1.PodSpec, sidecarName string, whiteList workload.Options) string {
+	switch {
+	case meta.Annotations[AnnotationSidecar] != "true":
+		return ReasonNotAnnotated
+	// 判断该工作负载是否已经在namespace白名单中
+	case tools.WhetherExists(meta.Namespace, whiteList.Namespaces):
+		return ReasonNamespaceWhiteList
+	// 判断该工作负载是否已经在deployment白名单中
+	case tools.WhetherExists(meta.Name, whiteList.Deployments):
+		return ReasonWorkloadWhiteList
+	// 判断该工作负载是否已经注入sidecar容器
+	case Injected(spec, sidecarName):
+		return ReasonAlreadyInjected
+	default:
+		return ""
+	}
+}
+
+// Injected 检查pod模版是否已经注入sidecar容器,包括以初始化容器注入的原生sidecar
+func Injected(spec corev1.PodSpec, sidecarName string) bool {
+	for _, c := range append(spec.Containers, spec.InitContainers...) {
+		if c.Name == sidecarName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deploy
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"strconv"
+)
+
+// resourceOverride 工作负载注释覆盖sidecar资源配置
+type resourceOverride struct {
+	annotation string
+	value      *string
+	min, max   string
+}
+
+// resources 使用工作负载注释覆盖sidecar容器的资源配置,返回覆盖后的配置与警告信息
+// 注释值无效时使用全局配置,超出配置的最小最大值时取边界值,requests大于limits时取limits
+func (d *deploy) resources(annotations map[string]string) (sidecar.Options, []string) {
+	options := d.sidecar
+	var warnings []string
+	for _, o := range []resourceOverride{
+		{"deployment.kubernetes.io/sidecar.resources.requests.cpu", &options.RequestsCPU, d.sidecar.MinCPU, d.sidecar.MaxCPU},
+		{"deployment.kubernetes.io/sidecar.resources.requests.memory", &options.RequestsMemory, d.sidecar.MinMemory, d.sidecar.MaxMemory},
+		{"deployment.kubernetes.io/sidecar.resources.limits.cpu", &options.LimitCPU, d.sidecar.MinCPU, d.sidecar.MaxCPU},
+		{"deployment.kubernetes.io/sidecar.resources.limits.memory", &options.LimitMemory, d.sidecar.MinMemory, d.sidecar.MaxMemory},
+	} {
+		value, ok := annotations[o.annotation]
+		if !ok {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			warnings = append(warnings, "注释"+o.annotation+"的值"+value+"无效,使用全局配置"+*o.value)
+			continue
+		}
+		if lower, err := resource.ParseQuantity(o.min); err == nil && q.Cmp(lower) < 0 {
+			warnings = append(warnings, "注释"+o.annotation+"的值"+value+"小于最小值"+o.min+",使用最小值")
+			q = lower
+		}
+		if upper, err := resource.ParseQuantity(o.max); err == nil && q.Cmp(upper) > 0 {
+			warnings = append(warnings, "注释"+o.annotation+"的值"+value+"大于最大值"+o.max+",使用最大值")
+			q = upper
+		}
+		*o.value = q.String()
+	}
+	for _, pair := range [][2]*string{
+		{&options.RequestsCPU, &options.LimitCPU},
+		{&options.RequestsMemory, &options.LimitMemory},
+	} {
+		requests, err := resource.ParseQuantity(*pair[0])
+		if err != nil {
+			continue
+		}
+		limits, err := resource.ParseQuantity(*pair[1])
+		if err == nil && requests.Cmp(limits) > 0 {
+			warnings = append(warnings, "sidecar资源requests "+*pair[0]+"大于limits "+*pair[1]+",使用limits")
+			*pair[0] = *pair[1]
+		}
+	}
+	return options, warnings
+}
+
+// positiveInt 使用工作负载注释覆盖正整数类型的fluentBit配置,注释值无效时使用全局配置并返回警告
+func positiveInt(annotations map[string]string, annotation string, global int) (int, []string) {
+	value, ok := annotations[annotation]
+	if !ok {
+		return global, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return global, []string{"注释" + annotation + "的值" + value + "无效,需要为正整数,使用全局配置" + strconv.Itoa(global)}
+	}
+	return n, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deploy
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/fluent"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/model/secret"
+	"strings"
+	"testing"
+)
+
+func TestInjectInvalidIntegerAnnotations(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	tests := []struct {
+		name     string
+		interval string
+		chunks   string
+		warnings int
+		want     []string
+	}{
+		{"valid", "5", "64", 0, []string{"Refresh_Interval 5", "storage.max_chunks_up 64"}},
+		{"typo", "5s", "many", 2, []string{"Refresh_Interval 20", "storage.max_chunks_up 128"}},
+		{"not positive", "0", "-1", 2, []string{"Refresh_Interval 20", "storage.max_chunks_up 128"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := metav1.ObjectMeta{
+				Name:      "app",
+				Namespace: "default",
+				Annotations: map[string]string{
+					"deployment.kubernetes.io/sidecar.backend":              "elasticsearch",
+					"deployment.kubernetes.io/sidecar.outputEsHost":         "es",
+					"deployment.kubernetes.io/sidecar.storageType":          "filesystem",
+					"deployment.kubernetes.io/sidecar.inputRefreshInterval": tt.interval,
+					"deployment.kubernetes.io/sidecar.storageMaxChunksUp":   tt.chunks,
+				},
+			}
+			spec := corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}}
+			d := NewDeploy(kubernetes.NewFakeClientSets(nil, nil, nil, "", nil), *fluent.NewFluentBitOptions(), *sidecar.NewSidecarOptions(), *controller.NewControllerOptions())
+			newSecret, warnings, err := d.(*deploy).Inject(&meta, &spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %v", warnings)
+			}
+			config := string(newSecret.Data[secret.ConfigKey])
+			for _, want := range tt.want {
+				if !strings.Contains(config, want) {
+					t.Errorf("config missing %q:\n%s", want, config)
+				}
+			}
+		})
+	}
+}
+
+func TestResourcesClamp(t *testing.T) {
+	logging.NewLoggingOptions().Logger()
+	const prefix = "deployment.kubernetes.io/sidecar.resources."
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        [4]string
+		warnings    int
+	}{
+		{"no annotations", nil, [4]string{"100m", "128Mi", "500m", "512Mi"}, 0},
+		{"within range", map[string]string{prefix + "requests.cpu": "200m", prefix + "limits.memory": "256Mi"}, [4]string{"200m", "128Mi", "500m", "256Mi"}, 0},
+		{"below min", map[string]string{prefix + "requests.cpu": "10m", prefix + "requests.memory": "1Mi"}, [4]string{"50m", "64Mi", "500m", "512Mi"}, 2},
+		{"above max", map[string]string{prefix + "limits.cpu": "4", prefix + "limits.memory": "8Gi"}, [4]string{"100m", "128Mi", "1", "1Gi"}, 2},
+		{"invalid", map[string]string{prefix + "requests.cpu": "lots"}, [4]string{"100m", "128Mi", "500m", "512Mi"}, 1},
+		{"requests above limits", map[string]string{prefix + "requests.cpu": "800m", prefix + "limits.cpu": "300m"}, [4]string{"300m", "128Mi", "300m", "512Mi"}, 1},
+		{"clamped requests above limits", map[string]string{prefix + "requests.memory": "4Gi", prefix + "limits.memory": "10Mi"}, [4]string{"100m", "64Mi", "500m", "64Mi"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := *sidecar.NewSidecarOptions()
+			options.RequestsCPU, options.RequestsMemory, options.LimitCPU, options.LimitMemory = "100m", "128Mi", "500m", "512Mi"
+			options.MinCPU, options.MaxCPU, options.MinMemory, options.MaxMemory = "50m", "1", "64Mi", "1Gi"
+			d := NewDeploy(kubernetes.NewFakeClientSets(nil, nil, nil, "", nil), *fluent.NewFluentBitOptions(), options, *controller.NewControllerOptions())
+			got, warnings := d.(*deploy).resources(tt.annotations)
+			if [4]string{got.RequestsCPU, got.RequestsMemory, got.LimitCPU, got.LimitMemory} != tt.want {
+				t.Errorf("resources = %s %s %s %s, want %v", got.RequestsCPU, got.RequestsMemory, got.LimitCPU, got.LimitMemory, tt.want)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %v", warnings)
+			}
+		})
+	}
+}
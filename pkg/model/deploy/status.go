@@ -0,0 +1,93 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"kube-sidecar/pkg/model/secret"
+	"time"
+)
+
+// 控制器写入工作负载的注入状态注释,便于通过kubectl get -o jsonpath或监控面板查询
+const (
+	AnnotationStatus        = "sidecar.kube-sidecar.io/status"
+	AnnotationImage         = "sidecar.kube-sidecar.io/image"
+	AnnotationLastReconcile = "sidecar.kube-sidecar.io/last-reconcile"
+	AnnotationLastError     = "sidecar.kube-sidecar.io/last-error"
+)
+
+// StatusAnnotations 所有注入状态注释,配置hash与secret使用相同的注释
+var StatusAnnotations = []string{
+	AnnotationStatus,
+	AnnotationImage,
+	secret.AnnotationConfigHash,
+	AnnotationLastReconcile,
+	AnnotationLastError,
+}
+
+// 注入状态
+const (
+	StatusInjected = "injected"
+	StatusFailed   = "failed"
+	StatusExcluded = "excluded"
+)
+
+// setInjectedStatus 在更新工作负载前写入注入成功的状态注释
+func setInjectedStatus(meta *metav1.ObjectMeta, spec *corev1.PodSpec, sidecarName string, newSecret *corev1.Secret) {
+	for _, c := range append(spec.Containers, spec.InitContainers...) {
+		if c.Name == sidecarName {
+			meta.Annotations[AnnotationImage] = c.Image
+		}
+	}
+	meta.Annotations[AnnotationStatus] = StatusInjected
+	meta.Annotations[secret.AnnotationConfigHash] = newSecret.Annotations[secret.AnnotationConfigHash]
+	meta.Annotations[AnnotationLastReconcile] = time.Now().UTC().Format(time.RFC3339)
+	delete(meta.Annotations, AnnotationLastError)
+}
+
+// RecordStatus 通过merge patch记录注入失败或被排除的状态,状态与错误信息未变化时不写入,
+// 避免写入注释触发的事件导致重复处理;dry-run模式下不写入
+func (d *deploy) RecordStatus(ctx context.Context, deployment *appsv1.Deployment, status, message string) error {
+	if d.controller.DryRun {
+		return nil
+	}
+	if deployment.Annotations[AnnotationStatus] == status && deployment.Annotations[AnnotationLastError] == message {
+		return nil
+	}
+	annotations := map[string]interface{}{
+		AnnotationStatus:        status,
+		AnnotationLastReconcile: time.Now().UTC().Format(time.RFC3339),
+		AnnotationLastError:     nil,
+	}
+	if message != "" {
+		annotations[AnnotationLastError] = message
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.k8sClient.Kubernetes().AppsV1().Deployments(deployment.Namespace).
+		Patch(ctx, deployment.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"kube-sidecar/pkg/clientset/kubernetes"
+)
+
+// Component 事件来源组件名称
+const Component = "kube-sidecar"
+
+// Recorder 全局事件记录器,默认丢弃所有事件,控制器启动时通过Start写入kubernetes
+var Recorder record.EventRecorder = &record.FakeRecorder{}
+
+// Start 初始化全局事件记录器,将事件写入工作负载所在namespace,返回停止函数
+func Start(k8sClient kubernetes.Client) func() {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: k8sClient.Kubernetes().CoreV1().Events(""),
+	})
+	Recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: Component})
+	return broadcaster.Shutdown
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/monitoring"
+	"kube-sidecar/pkg/model/container"
+	"kube-sidecar/pkg/model/secret"
+)
+
+// LabelManagedBy kube-sidecar管理的监控资源标签
+const LabelManagedBy = "app.kubernetes.io/managed-by"
+
+type podMonitor struct {
+	k8sClient  kubernetes.Client
+	monitoring monitoring.Options
+	controller controller.Options
+}
+
+type PodMonitor interface {
+	Apply(ctx context.Context, monitor *monitoringv1.PodMonitor) error
+	Delete(ctx context.Context, namespace, workload string) error
+}
+
+func NewPodMonitor(k8sClient kubernetes.Client, monitoring monitoring.Options, controller controller.Options) PodMonitor {
+	return &podMonitor{
+		k8sClient:  k8sClient,
+		monitoring: monitoring,
+		controller: controller,
+	}
+}
+
+// Name 获取工作负载对应的PodMonitor名称,与fluentBit secret同名
+func Name(workload string) string {
+	return secret.Name(workload)
+}
+
+// Build 生成采集工作负载sidecar指标的PodMonitor对象,owner不为空时随工作负载一起删除
+func Build(meta metav1.ObjectMeta, selector metav1.LabelSelector, owner *metav1.OwnerReference, options monitoring.Options) *monitoringv1.PodMonitor {
+	monitor := &monitoringv1.PodMonitor{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringv1.SchemeGroupVersion.String(),
+			Kind:       monitoringv1.PodMonitorsKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      Name(meta.Name),
+			Namespace: meta.Namespace,
+			Labels:    managedLabels(options),
+			Annotations: map[string]string{
+				secret.AnnotationWorkload: meta.Name,
+			},
+		},
+		Spec: monitoringv1.PodMonitorSpec{
+			Selector: selector,
+			PodMetricsEndpoints: []monitoringv1.PodMetricsEndpoint{
+				{
+					Port:     container.MetricsPortName,
+					Path:     container.MetricsPath,
+					Interval: options.Interval,
+				},
+			},
+		},
+	}
+	if owner != nil {
+		monitor.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	return monitor
+}
+
+// Apply 创建PodMonitor,已存在时更新,dry-run模式下只在服务端校验不实际写入。
+// 与Delete一致,只更新kube-sidecar为同一工作负载创建的对象,同名的其他PodMonitor不覆盖并返回错误
+func (p *podMonitor) Apply(ctx context.Context, monitor *monitoringv1.PodMonitor) error {
+	var dryRun []string
+	if p.controller.DryRun {
+		dryRun = []string{metav1.DryRunAll}
+	}
+	monitors := p.k8sClient.Prometheus().MonitoringV1().PodMonitors(monitor.Namespace)
+	// 先查询,已注入的deployment每次变更都会调用,避免重复的Create请求
+	current, err := monitors.Get(ctx, monitor.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = monitors.Create(ctx, monitor, metav1.CreateOptions{DryRun: dryRun})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	workload := monitor.Annotations[secret.AnnotationWorkload]
+	if current.Annotations[secret.AnnotationWorkload] != workload {
+		return fmt.Errorf("PodMonitor %s/%s不是kube-sidecar为工作负载%s创建的,拒绝覆盖", monitor.Namespace, monitor.Name, workload)
+	}
+	// 配置未变化时不更新,避免每次deployment事件都写入
+	if equality.Semantic.DeepEqual(current.Spec, monitor.Spec) && equality.Semantic.DeepEqual(current.Labels, monitor.Labels) {
+		return nil
+	}
+	monitor.ResourceVersion = current.ResourceVersion
+	_, err = monitors.Update(ctx, monitor, metav1.UpdateOptions{DryRun: dryRun})
+	return err
+}
+
+// Delete 删除工作负载对应的PodMonitor,只删除kube-sidecar创建的对象,不存在时忽略
+func (p *podMonitor) Delete(ctx context.Context, namespace, workload string) error {
+	monitors := p.k8sClient.Prometheus().MonitoringV1().PodMonitors(namespace)
+	current, err := monitors.Get(ctx, Name(workload), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Annotations[secret.AnnotationWorkload] != workload {
+		return nil
+	}
+	options := metav1.DeleteOptions{}
+	if p.controller.DryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	err = monitors.Delete(ctx, current.Name, options)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitor
+
+import (
+	"context"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	promfake "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/monitoring"
+	"kube-sidecar/pkg/model/secret"
+	"testing"
+)
+
+func TestPodMonitorOwnership(t *testing.T) {
+	options := *monitoring.NewMonitoringOptions()
+	foreign := &monitoringv1.PodMonitor{ObjectMeta: metav1.ObjectMeta{Name: Name("other"), Namespace: "default"}}
+	clientset := promfake.NewSimpleClientset(foreign)
+	client := kubernetes.NewFakeClientSets(nil, nil, clientset, "", nil)
+	newMonitor := func(name string) *monitoringv1.PodMonitor {
+		meta := metav1.ObjectMeta{Name: name, Namespace: "default"}
+		return Build(meta, metav1.LabelSelector{MatchLabels: map[string]string{"app": name}}, nil, options)
+	}
+	p := NewPodMonitor(client, options, *controller.NewControllerOptions())
+	if err := p.Apply(context.TODO(), newMonitor("app")); err != nil {
+		t.Fatal(err)
+	}
+	// 配置未变化时只查询不写入
+	clientset.ClearActions()
+	if err := p.Apply(context.TODO(), newMonitor("app")); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("unexpected %s on unchanged PodMonitor", action.GetVerb())
+		}
+	}
+	// 同名但不是kube-sidecar创建的PodMonitor不覆盖也不删除
+	if err := p.Apply(context.TODO(), newMonitor("other")); err == nil {
+		t.Error("expected error when PodMonitor is not owned")
+	}
+	// 控制器重启后(新的实例,没有内存状态)依然可以清理
+	restarted := NewPodMonitor(client, options, *controller.NewControllerOptions())
+	for _, workload := range []string{"app", "other", "missing"} {
+		if err := restarted.Delete(context.TODO(), "default", workload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	monitors, err := clientset.MonitoringV1().PodMonitors("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(monitors.Items) != 1 || monitors.Items[0].Name != Name("other") || monitors.Items[0].Annotations[secret.AnnotationWorkload] != "" {
+		t.Errorf("remaining PodMonitors = %v", monitors.Items)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"kube-sidecar/pkg/clientset/monitoring"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RuleName kube-sidecar管理的PrometheusRule名称
+const RuleName = "kube-sidecar"
+
+// alert 告警规则模版,metric为计数器指标
+type alert struct {
+	name      string
+	metric    string
+	threshold float64
+	summary   string
+}
+
+type prometheusRule struct {
+	k8sClient  kubernetes.Client
+	controller controller.Options
+}
+
+type PrometheusRule interface {
+	Apply(ctx context.Context, rule *monitoringv1.PrometheusRule) error
+}
+
+func NewPrometheusRule(k8sClient kubernetes.Client, controller controller.Options) PrometheusRule {
+	return &prometheusRule{
+		k8sClient:  k8sClient,
+		controller: controller,
+	}
+}
+
+// BuildRule 生成sidecar日志投递告警规则,配置了路由标签的namespace单独分组并附加标签,其余namespace使用默认分组
+func BuildRule(sidecarName string, options monitoring.Options) *monitoringv1.PrometheusRule {
+	alerts := []alert{
+		{"SidecarOutputRetries", "fluentbit_output_retries_total", options.RetriesThreshold, "fluentBit output重试次数过多"},
+		{"SidecarOutputRetriesFailed", "fluentbit_output_retries_failed_total", options.RetriesFailedThreshold, "fluentBit output重试失败,日志已丢弃"},
+		{"SidecarOutputErrors", "fluentbit_output_errors_total", options.ErrorsThreshold, "fluentBit output发送失败"},
+		{"SidecarDroppedRecords", "fluentbit_output_dropped_records_total", options.DroppedRecordsThreshold, "fluentBit丢弃日志记录"},
+		{"SidecarRestarting", "kube_pod_container_status_restarts_total", options.RestartsThreshold, "sidecar容器频繁重启"},
+	}
+	namespaces := make([]string, 0, len(options.NamespaceRoutes))
+	for ns := range options.NamespaceRoutes {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	// 默认分组排除已配置路由的namespace,避免重复告警
+	selector := fmt.Sprintf("container=%q", sidecarName)
+	if len(namespaces) > 0 {
+		quoted := make([]string, len(namespaces))
+		for i, ns := range namespaces {
+			quoted[i] = regexp.QuoteMeta(ns)
+		}
+		selector += fmt.Sprintf(",namespace!~%q", strings.Join(quoted, "|"))
+	}
+	groups := []monitoringv1.RuleGroup{ruleGroup("kube-sidecar", selector, nil, alerts, options)}
+	for _, ns := range namespaces {
+		groups = append(groups, ruleGroup("kube-sidecar-"+ns,
+			fmt.Sprintf("container=%q,namespace=%q", sidecarName, ns), options.NamespaceRoutes[ns], alerts, options))
+	}
+	return &monitoringv1.PrometheusRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringv1.SchemeGroupVersion.String(),
+			Kind:       monitoringv1.PrometheusRuleKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RuleName,
+			Namespace: options.RuleNamespace,
+			Labels:    managedLabels(options),
+		},
+		Spec: monitoringv1.PrometheusRuleSpec{Groups: groups},
+	}
+}
+
+// ruleGroup 生成一个告警分组,routes为附加到告警的路由标签
+func ruleGroup(name, selector string, routes map[string]string, alerts []alert, options monitoring.Options) monitoringv1.RuleGroup {
+	group := monitoringv1.RuleGroup{Name: name}
+	for _, a := range alerts {
+		labels := map[string]string{"severity": options.Severity}
+		for k, v := range routes {
+			labels[k] = v
+		}
+		group.Rules = append(group.Rules, monitoringv1.Rule{
+			Alert: a.name,
+			Expr: intstr.FromString(fmt.Sprintf("sum by (namespace, pod) (increase(%s{%s}[%s])) > %s",
+				a.metric, selector, options.RuleWindow, strconv.FormatFloat(a.threshold, 'f', -1, 64))),
+			For:    options.RuleFor,
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     a.summary,
+				"description": "{{ $labels.namespace }}/{{ $labels.pod }} " + a.summary + ",最近" + options.RuleWindow + "增加{{ $value }}",
+			},
+		})
+	}
+	return group
+}
+
+// managedLabels kube-sidecar管理的监控资源标签
+func managedLabels(options monitoring.Options) map[string]string {
+	labels := map[string]string{LabelManagedBy: "kube-sidecar"}
+	for k, v := range options.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Apply 创建PrometheusRule,已存在且规则变化时更新,dry-run模式下只在服务端校验不实际写入
+func (p *prometheusRule) Apply(ctx context.Context, rule *monitoringv1.PrometheusRule) error {
+	var dryRun []string
+	if p.controller.DryRun {
+		dryRun = []string{metav1.DryRunAll}
+	}
+	rules := p.k8sClient.Prometheus().MonitoringV1().PrometheusRules(rule.Namespace)
+	_, err := rules.Create(ctx, rule, metav1.CreateOptions{DryRun: dryRun})
+	if !errors.IsAlreadyExists(err) {
+		return err
+	}
+	current, err := rules.Get(ctx, rule.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(current.Spec, rule.Spec) && equality.Semantic.DeepEqual(current.Labels, rule.Labels) {
+		return nil
+	}
+	rule.ResourceVersion = current.ResourceVersion
+	_, err = rules.Update(ctx, rule, metav1.UpdateOptions{DryRun: dryRun})
+	return err
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitor
+
+import (
+	"kube-sidecar/pkg/clientset/monitoring"
+	"strings"
+	"testing"
+)
+
+func TestBuildRule(t *testing.T) {
+	options := *monitoring.NewMonitoringOptions()
+	options.NamespaceRoutes = map[string]map[string]string{
+		"payments": {"team": "pay"},
+	}
+	rule := BuildRule("sidecar", options)
+	if len(rule.Spec.Groups) != 2 {
+		t.Fatalf("groups = %d, want 2", len(rule.Spec.Groups))
+	}
+	defaults, routed := rule.Spec.Groups[0], rule.Spec.Groups[1]
+	if expr := defaults.Rules[0].Expr.String(); !strings.Contains(expr, `namespace!~"payments"`) || !strings.HasSuffix(expr, "> 10") {
+		t.Errorf("default expr = %s", expr)
+	}
+	if expr := routed.Rules[0].Expr.String(); !strings.Contains(expr, `container="sidecar",namespace="payments"`) {
+		t.Errorf("routed expr = %s", expr)
+	}
+	if routed.Rules[0].Labels["team"] != "pay" || defaults.Rules[0].Labels["team"] != "" {
+		t.Errorf("route labels: default %v, routed %v", defaults.Rules[0].Labels, routed.Rules[0].Labels)
+	}
+}
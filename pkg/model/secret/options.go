@@ -19,6 +19,7 @@ package secret
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
 	"kube-sidecar/pkg/clientset/elastic"
 	"kube-sidecar/pkg/clientset/fluent"
 	"kube-sidecar/pkg/clientset/kafka"
@@ -28,97 +29,129 @@ import (
 
 // fluentBit全局配置
 const (
-	fluentBit = `
+	// fluentBitCommon 各个后端共用的SERVICE与INPUT配置块
+	fluentBitCommon = `
+{{- define "service"}}
 [SERVICE]
     HTTP_Server on
     HTTP_Listen 0.0.0.0
     HTTP_Port 2020
-	Health_Check On
-	HC_Errors_Count 5
-	HC_Retry_Failure_Count 5
-	HC_Period 5
-	Log_Level {{.ServiceLogLevel}}
+    Health_Check On
+    HC_Errors_Count 5
+    HC_Retry_Failure_Count 5
+    HC_Period 5
+    Log_Level {{.ServiceLogLevel}}
+{{- if .ServiceGrace}}
+    Grace {{.ServiceGrace}}
+{{- end}}
+    Parsers_File /fluent-bit/etc/parsers.conf
+{{- if eq .StorageType "filesystem"}}
+    storage.path {{.StoragePath}}/buffer
+    storage.sync normal
+    storage.checksum off
+    storage.backlog.mem_limit {{.StorageBacklogMemLimit}}
+    storage.max_chunks_up {{.StorageMaxChunksUp}}
+{{- end}}
+{{- end}}
+{{- define "input"}}
 [INPUT]
     Name tail
     Path {{.InputLogPath}}/*.logging
     Parser docker
     Tag {{.InputAppName}}.logging
-    DB /var/logging/{{.InputAppName}}/flb-db
+    DB {{.StoragePath}}/{{.InputAppName}}-flb.db
     Mem_Buf_Limit {{.InputMemBufLimit}}
     Skip_Long_Lines On
     Refresh_Interval {{.InputRefreshInterval}}
+{{- if eq .StorageType "filesystem"}}
+    storage.type filesystem
+{{- end}}
+{{- end}}
+{{- define "filters"}}
+{{- range $i, $f := .Filters}}
+[FILTER]
+{{- range $f.Entries (printf "%s.logging" $.InputAppName) $i}}
+    {{.Key}} {{.Value}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{- define "storage"}}
+{{- if and (eq .StorageType "filesystem") .StorageTotalLimitSize}}
+    storage.total_limit_size {{.StorageTotalLimitSize}}
+{{- end}}
+{{- end}}`
+	fluentBit = `
+{{- template "service" .}}
+{{- template "input" .}}
+{{- template "filters" .}}
 [OUTPUT]
     Name es
     Match {{.InputAppName}}.logging
     Host {{.OutputEsHost}}
     Port {{.OutputEsPort}}
+{{- if .OutputEsIndex}}
     Index {{.OutputEsIndex}}
-    User {{.OutputEsUser}}
-    Password {{.OutputEsPassword}}
+{{- else}}
+    Logstash_Format On
+    Logstash_Prefix {{.InputAppName}}
+    Logstash_DateFormat %Y-%m-%d
+{{- end}}
+{{- if .OutputEsUser}}
+    HTTP_User {{.OutputEsUser}}
+    HTTP_Passwd {{.OutputEsPassword}}
+{{- end}}
+{{- template "storage" .}}
 [OUTPUT]
     Name kafka
     Match {{.InputAppName}}.logging
-    brokers {{.OutputKafkaHost}}:{{.OutputKafkaPort}}
-    Topic {{.OutputKafkaTopic}}
-    User {{.OutputKafkaUser}}
-    Password {{.OutputKafkaPassword}}
-    Security_Protocol SASL_PLAINTEXT
+    Brokers {{.OutputKafkaHost}}:{{.OutputKafkaPort}}
+    Topics {{.OutputKafkaTopic}}
+{{- if .OutputKafkaUser}}
+    rdkafka.security.protocol SASL_PLAINTEXT
+    rdkafka.sasl.mechanism PLAIN
+    rdkafka.sasl.username {{.OutputKafkaUser}}
+    rdkafka.sasl.password {{.OutputKafkaPassword}}
+{{- end}}
+{{- template "storage" .}}
 `
 	fluentBitKafka = `
-[SERVICE]
-	HTTP_Server on
-	HTTP_Listen 0.0.0.0
-	HTTP_Port 2020
-	Health_Check On
-	HC_Errors_Count 5
-	HC_Retry_Failure_Count 5
-	HC_Period 5
-	Log_Level {{.ServiceLogLevel}}
-[INPUT]
-	Name tail
-	Path {{.InputLogPath}}/*.logging
-	Parser docker
-	Tag {{.InputAppName}}.logging
-	DB /var/logging/{{.InputAppName}}/flb-db
-	Mem_Buf_Limit {{.InputMemBufLimit}}
-	Skip_Long_Lines On
-	Refresh_Interval {{.InputRefreshInterval}}
+{{- template "service" .}}
+{{- template "input" .}}
+{{- template "filters" .}}
 [OUTPUT]
-	Name kafka
-	Match {{.InputAppName}}.logging
-	brokers {{.OutputKafkaHost}}:{{.OutputKafkaPort}}
-	Topic {{.OutputKafkaTopic}}
-	User {{.OutputKafkaUser}}
-	Password {{.OutputKafkaPassword}}
-	Security_Protocol SASL_PLAINTEXT
+    Name kafka
+    Match {{.InputAppName}}.logging
+    Brokers {{.OutputKafkaHost}}:{{.OutputKafkaPort}}
+    Topics {{.OutputKafkaTopic}}
+{{- if .OutputKafkaUser}}
+    rdkafka.security.protocol SASL_PLAINTEXT
+    rdkafka.sasl.mechanism PLAIN
+    rdkafka.sasl.username {{.OutputKafkaUser}}
+    rdkafka.sasl.password {{.OutputKafkaPassword}}
+{{- end}}
+{{- template "storage" .}}
 `
 	fluentBitES = `
-[SERVICE]
-	HTTP_Server on
-	HTTP_Listen 0.0.0.0
-	HTTP_Port 2020
-	Health_Check On
-	HC_Errors_Count 5
-	HC_Retry_Failure_Count 5
-	HC_Period 5
-	Log_Level {{.ServiceLogLevel}}
-[INPUT]
-	Name tail
-	Path {{.InputLogPath}}/*.logging
-	Parser docker
-	Tag {{.InputAppName}}.logging
-	DB /var/logging/{{.InputAppName}}/flb-db
-	Mem_Buf_Limit {{.InputMemBufLimit}}
-	Skip_Long_Lines On
-	Refresh_Interval {{.InputRefreshInterval}}
+{{- template "service" .}}
+{{- template "input" .}}
+{{- template "filters" .}}
 [OUTPUT]
-	Name es
-	Match {{.InputAppName}}.logging
-	Host {{.OutputEsHost}}
-	Port {{.OutputEsPort}}
-	Index {{.OutputEsIndex}}
-	User {{.OutputEsUser}}
-	Password {{.OutputEsPassword}}
+    Name es
+    Match {{.InputAppName}}.logging
+    Host {{.OutputEsHost}}
+    Port {{.OutputEsPort}}
+{{- if .OutputEsIndex}}
+    Index {{.OutputEsIndex}}
+{{- else}}
+    Logstash_Format On
+    Logstash_Prefix {{.InputAppName}}
+    Logstash_DateFormat %Y-%m-%d
+{{- end}}
+{{- if .OutputEsUser}}
+    HTTP_User {{.OutputEsUser}}
+    HTTP_Passwd {{.OutputEsPassword}}
+{{- end}}
+{{- template "storage" .}}
 `
 )
 
@@ -142,22 +175,35 @@ func FluentBitTemplate(backend string, fluent fluent.Options) ([]byte, error) {
 		// 设置bytes.Buffer对象
 		buf bytes.Buffer
 	)
+	// 校验FILTER配置
+	for i, f := range fluent.Filters {
+		if err = f.Validate(); err != nil {
+			return nil, fmt.Errorf("第%d个filter配置错误: %w", i, err)
+		}
+	}
 	switch backend {
 	case "kafka":
 		// 应用模版并输出保存文件中
 		k := kafka.OutputKafka{
-			ServiceLogLevel:      fluent.ServiceLogLevel,
-			InputLogPath:         fluent.InputLogPath,
-			InputAppName:         fluent.InputAppName,
-			InputMemBufLimit:     fluent.InputMemBufLimit,
-			InputRefreshInterval: fluent.InputRefreshInterval,
-			OutputKafkaHost:      fluent.OutputKafkaHost,
-			OutputKafkaPort:      fluent.OutputKafkaPort,
-			OutputKafkaUser:      fluent.OutputKafkaUser,
-			OutputKafkaTopic:     fluent.OutputKafkaTopic,
-			OutputKafkaPassword:  fluent.OutputKafkaPassword,
+			ServiceLogLevel:        fluent.ServiceLogLevel,
+			ServiceGrace:           fluent.ServiceGrace,
+			InputLogPath:           fluent.InputLogPath,
+			InputAppName:           fluent.InputAppName,
+			InputMemBufLimit:       fluent.InputMemBufLimit,
+			InputRefreshInterval:   fluent.InputRefreshInterval,
+			OutputKafkaHost:        fluent.OutputKafkaHost,
+			OutputKafkaPort:        fluent.OutputKafkaPort,
+			OutputKafkaUser:        fluent.OutputKafkaUser,
+			OutputKafkaTopic:       fluent.OutputKafkaTopic,
+			OutputKafkaPassword:    fluent.OutputKafkaPassword,
+			StoragePath:            fluent.StoragePath,
+			StorageType:            fluent.StorageType,
+			StorageBacklogMemLimit: fluent.StorageBacklogMemLimit,
+			StorageMaxChunksUp:     fluent.StorageMaxChunksUp,
+			StorageTotalLimitSize:  fluent.StorageTotalLimitSize,
+			Filters:                fluent.Filters,
 		}
-		tpl, err = template.New("fluentBit-kafka").Parse(fluentBitKafka)
+		tpl, err = newTemplate("fluentBit-kafka", fluentBitKafka)
 		if err != nil {
 			logging.Logger.Error(err.Error())
 			return nil, err
@@ -170,18 +216,25 @@ func FluentBitTemplate(backend string, fluent fluent.Options) ([]byte, error) {
 	case "elasticsearch":
 		// 应用模版并输出保存文件中
 		r := elastic.OutputElasticsearch{
-			ServiceLogLevel:      fluent.ServiceLogLevel,
-			InputLogPath:         fluent.InputLogPath,
-			InputAppName:         fluent.InputAppName,
-			InputMemBufLimit:     fluent.InputMemBufLimit,
-			InputRefreshInterval: fluent.InputRefreshInterval,
-			OutputEsHost:         fluent.OutputEsHost,
-			OutputEsPort:         fluent.OutputEsPort,
-			OutputEsIndex:        fluent.OutputEsIndex,
-			OutputEsUser:         fluent.OutputEsUser,
-			OutputEsPassword:     fluent.OutputEsPassword,
+			ServiceLogLevel:        fluent.ServiceLogLevel,
+			ServiceGrace:           fluent.ServiceGrace,
+			InputLogPath:           fluent.InputLogPath,
+			InputAppName:           fluent.InputAppName,
+			InputMemBufLimit:       fluent.InputMemBufLimit,
+			InputRefreshInterval:   fluent.InputRefreshInterval,
+			OutputEsHost:           fluent.OutputEsHost,
+			OutputEsPort:           fluent.OutputEsPort,
+			OutputEsIndex:          fluent.OutputEsIndex,
+			OutputEsUser:           fluent.OutputEsUser,
+			OutputEsPassword:       fluent.OutputEsPassword,
+			StoragePath:            fluent.StoragePath,
+			StorageType:            fluent.StorageType,
+			StorageBacklogMemLimit: fluent.StorageBacklogMemLimit,
+			StorageMaxChunksUp:     fluent.StorageMaxChunksUp,
+			StorageTotalLimitSize:  fluent.StorageTotalLimitSize,
+			Filters:                fluent.Filters,
 		}
-		tpl, err = template.New("fluentBit-elasticsearch").Parse(fluentBitES)
+		tpl, err = newTemplate("fluentBit-elasticsearch", fluentBitES)
 		if err != nil {
 			logging.Logger.Error(err.Error())
 			return nil, err
@@ -193,12 +246,37 @@ func FluentBitTemplate(backend string, fluent fluent.Options) ([]byte, error) {
 		}
 
 	default:
-		tpl, err = template.New("fluentBit").Parse(fluentBit)
+		tpl, err = newTemplate("fluentBit", fluentBit)
 		if err != nil {
 			logging.Logger.Error(err.Error())
 			return nil, err
 		}
+		err = tpl.Execute(&buf, fluent)
+		if err != nil {
+			logging.Logger.Error(err.Error())
+			return nil, err
+		}
+	}
+	// buf.Bytes()将bytes.Buffer转为[]byte,去除模版开头的空行
+	return bytes.TrimLeft(buf.Bytes(), "\n"), nil
+}
+
+// FluentBitScripts 获取FILTER流水线中lua脚本,key为secret中的文件名称
+func FluentBitScripts(options fluent.Options) map[string][]byte {
+	scripts := make(map[string][]byte)
+	for i, f := range options.Filters {
+		if f.Name == fluent.FilterLua {
+			scripts[fluent.ScriptName(i)] = []byte(f.Script)
+		}
+	}
+	return scripts
+}
+
+// newTemplate 解析后端模版并加载公共的SERVICE与INPUT配置块
+func newTemplate(name, text string) (*template.Template, error) {
+	tpl, err := template.New(name).Parse(fluentBitCommon)
+	if err != nil {
+		return nil, err
 	}
-	// buf.Bytes()将bytes.Buffer转为[]byte
-	return buf.Bytes(), nil
+	return tpl.Parse(text)
 }
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"kube-sidecar/pkg/clientset/fluent"
+	"kube-sidecar/pkg/validation"
+	"strings"
+	"testing"
+)
+
+// templateOptions 渲染output模版使用的最小配置
+func templateOptions() fluent.Options {
+	options := *fluent.NewFluentBitOptions()
+	options.InputAppName = "app"
+	options.InputLogPath = "/var/log/app"
+	options.OutputEsHost = "es"
+	options.OutputEsPort = "9200"
+	options.OutputKafkaHost = "kafka"
+	options.OutputKafkaPort = "9092"
+	options.OutputKafkaTopic = "logs"
+	return options
+}
+
+func TestEsIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		index   string
+		want    []string
+		absent  []string
+	}{
+		{"daily default", "elasticsearch", "", []string{"Logstash_Format On", "Logstash_Prefix app", "Logstash_DateFormat %Y-%m-%d"}, []string{"Index "}},
+		{"fixed index", "elasticsearch", "app-logs", []string{"Index app-logs"}, []string{"Logstash_"}},
+		{"both daily default", "", "", []string{"Logstash_Format On", "Logstash_Prefix app"}, []string{"Index "}},
+		{"both fixed index", "", "app-logs", []string{"Index app-logs"}, []string{"Logstash_"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := templateOptions()
+			options.OutputEsIndex = tt.index
+			data, err := FluentBitTemplate(tt.backend, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := string(data)
+			for _, want := range tt.want {
+				if !strings.Contains(config, want) {
+					t.Errorf("config missing %q:\n%s", want, config)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(config, absent) {
+					t.Errorf("config contains %q:\n%s", absent, config)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputKeys(t *testing.T) {
+	esAuth := []string{"HTTP_User elastic", "HTTP_Passwd secret"}
+	kafka := []string{"Brokers kafka:9092", "Topics logs"}
+	kafkaAuth := []string{"rdkafka.security.protocol SASL_PLAINTEXT", "rdkafka.sasl.mechanism PLAIN", "rdkafka.sasl.username producer", "rdkafka.sasl.password secret"}
+	// 之前版本使用的fluentBit不支持的key
+	legacy := []string{"    User ", "    Password ", "    Topic ", "    brokers ", "Security_Protocol"}
+	tests := []struct {
+		name        string
+		backend     string
+		credentials bool
+		want        []string
+		absent      []string
+	}{
+		{"es", "elasticsearch", false, []string{"Host es", "Port 9200"}, append([]string{"HTTP_User", "HTTP_Passwd"}, legacy...)},
+		{"es credentials", "elasticsearch", true, esAuth, legacy},
+		{"kafka", "kafka", false, kafka, append([]string{"rdkafka."}, legacy...)},
+		{"kafka credentials", "kafka", true, append(kafka, kafkaAuth...), legacy},
+		{"both credentials", "", true, append(append(esAuth, kafka...), kafkaAuth...), legacy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := templateOptions()
+			if tt.credentials {
+				options.OutputEsUser, options.OutputEsPassword = "elastic", "secret"
+				options.OutputKafkaUser, options.OutputKafkaPassword = "producer", "secret"
+			}
+			data, err := FluentBitTemplate(tt.backend, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := string(data)
+			for _, want := range tt.want {
+				if !strings.Contains(config, want) {
+					t.Errorf("config missing %q:\n%s", want, config)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(config, absent) {
+					t.Errorf("config contains %q:\n%s", absent, config)
+				}
+			}
+			if err = validation.Validate(data).Err(); err != nil {
+				t.Errorf("rendered config invalid: %v", err)
+			}
+		})
+	}
+}
@@ -18,61 +18,198 @@ package secret
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kube-sidecar/pkg/clientset/controller"
 	"kube-sidecar/pkg/clientset/fluent"
 	"kube-sidecar/pkg/clientset/kubernetes"
 	lg "kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/metrics"
+	"kube-sidecar/pkg/validation"
+	"sort"
 	"strings"
 )
 
+// ConfigKey secret中fluentBit配置文件的key
+const ConfigKey = "fluent-bit.conf"
+
+// kube-sidecar生成的secret注释
+const (
+	// AnnotationWorkload 标记secret由kube-sidecar为该工作负载生成
+	AnnotationWorkload = "sidecar.kube-sidecar.io/workload"
+	// AnnotationConfigHash 生成secret时配置内容的hash
+	AnnotationConfigHash = "sidecar.kube-sidecar.io/config-hash"
+)
+
 type secret struct {
-	k8sClient kubernetes.Client
+	k8sClient  kubernetes.Client
+	controller controller.Options
 }
 
 type Secret interface {
 	FluentBit(backend, name, namespace string, fluent fluent.Options) error
+	Apply(ctx context.Context, secret *corev1.Secret) error
+	Delete(ctx context.Context, namespace, workload string) error
 }
 
-func NewSecret(k8sClient kubernetes.Client) Secret {
+func NewSecret(k8sClient kubernetes.Client, controller controller.Options) Secret {
 	return &secret{
-		k8sClient: k8sClient,
+		k8sClient:  k8sClient,
+		controller: controller,
 	}
 }
 
-// FluentBit 创建secret方法
-func (s *secret) FluentBit(backend, name, namespace string, fluent fluent.Options) error {
+// Name 获取工作负载对应的fluentBit secret名称
+func Name(workload string) string {
+	return strings.Join([]string{workload, "sidecar"}, "-")
+}
+
+// Build 生成工作负载的fluentBit secret对象,不调用kubernetes接口
+func Build(backend, name, namespace string, fluent fluent.Options) (*corev1.Secret, error) {
 	// 生成fluentBit secret []byte数据
-	data, err := s.GenerateFluentBitConfig(backend, fluent)
+	data, err := GenerateFluentBitConfig(backend, fluent)
 	if err != nil {
 		lg.Logger.Error("生成fluentBit配置文件失败,错误信息" + err.Error())
-		return nil
+		return nil, err
 	}
 	// 创建一个新的secret对象
 	newSecret := &corev1.Secret{
+		TypeMeta: v1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
 		ObjectMeta: v1.ObjectMeta{
-			Name:      strings.Join([]string{name, "sidecar"}, "-"),
+			Name:      Name(name),
 			Namespace: namespace,
+			Annotations: map[string]string{
+				AnnotationWorkload: name,
+			},
 		},
 		Data: map[string][]byte{
-			"fluent-bit.conf": data,
+			ConfigKey: data,
 		},
 	}
-	// 创建secret
-	createdSecret, err := s.k8sClient.Kubernetes().CoreV1().Secrets(namespace).Create(context.Background(), newSecret, v1.CreateOptions{})
+	// 添加FILTER流水线使用的lua脚本
+	for key, script := range FluentBitScripts(fluent) {
+		newSecret.Data[key] = script
+	}
+	newSecret.Annotations[AnnotationConfigHash] = Hash(newSecret.Data)
+	return newSecret, nil
+}
+
+// Hash 计算secret数据的hash,用于判断集群中的配置是否与期望配置一致
+func Hash(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write(data[k])
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// FluentBit 创建secret方法
+func (s *secret) FluentBit(backend, name, namespace string, fluent fluent.Options) error {
+	newSecret, err := Build(backend, name, namespace, fluent)
 	if err != nil {
-		lg.Logger.Error(err.Error())
+		return err
+	}
+	return s.Apply(context.TODO(), newSecret)
+}
+
+// Apply 创建secret,secret已存在时更新,dry-run模式下只在服务端校验不实际写入。
+// 与Delete一致,只更新带有相同工作负载标记的secret,同名的其他secret不覆盖并返回错误
+func (s *secret) Apply(ctx context.Context, newSecret *corev1.Secret) error {
+	var dryRun []string
+	if s.controller.DryRun {
+		dryRun = []string{v1.DryRunAll}
+	}
+	secrets := s.k8sClient.Kubernetes().CoreV1().Secrets(newSecret.Namespace)
+	operation := metrics.OperationCreated
+	workload := newSecret.Annotations[AnnotationWorkload]
+	current, err := secrets.Get(ctx, newSecret.Name, v1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		_, err = secrets.Create(ctx, newSecret, v1.CreateOptions{DryRun: dryRun})
+	case err != nil:
+	case current.Annotations[AnnotationWorkload] != workload:
+		err = fmt.Errorf("secret %s/%s不是kube-sidecar为工作负载%s生成的,拒绝覆盖", newSecret.Namespace, newSecret.Name, workload)
+	default:
+		operation = metrics.OperationUpdated
+		_, err = secrets.Update(ctx, newSecret, v1.UpdateOptions{DryRun: dryRun})
 	}
-	lg.Logger.Info("namespace " + createdSecret.Namespace + "创建secret " + createdSecret.Name + "成功!")
+	log := lg.FromContext(ctx).With(zap.String("secret", newSecret.Name))
+	if err != nil {
+		log.Error("创建secret失败", zap.Error(err))
+		return err
+	}
+	if s.controller.DryRun {
+		log.Info("dry-run模式,将创建或更新secret,未实际写入")
+		return nil
+	}
+	metrics.Secrets.WithLabelValues(operation).Inc()
+	log.Info("创建secret成功", zap.String("operation", operation))
+	return nil
+}
+
+// Delete 删除kube-sidecar为工作负载生成的secret,只删除带有工作负载标记的secret,不存在时忽略
+func (s *secret) Delete(ctx context.Context, namespace, workload string) error {
+	secrets := s.k8sClient.Kubernetes().CoreV1().Secrets(namespace)
+	current, err := secrets.Get(ctx, Name(workload), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Annotations[AnnotationWorkload] != workload {
+		return nil
+	}
+	var dryRun []string
+	if s.controller.DryRun {
+		dryRun = []string{v1.DryRunAll}
+	}
+	err = secrets.Delete(ctx, current.Name, v1.DeleteOptions{DryRun: dryRun})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.controller.DryRun {
+		lg.FromContext(ctx).Info("dry-run模式,将删除secret,未实际写入", zap.String("secret", current.Name))
+		return nil
+	}
+	metrics.Secrets.WithLabelValues(metrics.OperationDeleted).Inc()
+	lg.FromContext(ctx).Info("删除secret成功", zap.String("secret", current.Name))
 	return nil
 }
 
 // GenerateFluentBitConfig 创建fluentBit配置文件模版,输出位[]byte
-func (s *secret) GenerateFluentBitConfig(backend string, fluent fluent.Options) ([]byte, error) {
+func GenerateFluentBitConfig(backend string, fluent fluent.Options) ([]byte, error) {
 	data, err := FluentBitTemplate(backend, fluent)
 	if err != nil {
 		lg.Logger.Error(err.Error())
 		return nil, err
 	}
+	// 写入secret之前校验渲染后的配置文件
+	problems := validation.Validate(data)
+	for _, p := range problems {
+		lg.Logger.Warn("fluentBit配置 " + fluent.InputAppName + " " + p.String())
+	}
+	if err = problems.Err(); err != nil {
+		return nil, err
+	}
 	return data, nil
 }
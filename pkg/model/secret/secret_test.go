@@ -0,0 +1,74 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"kube-sidecar/pkg/clientset/controller"
+	"kube-sidecar/pkg/clientset/kubernetes"
+	"testing"
+)
+
+func TestApplyOwnership(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *corev1.Secret
+		wantErr  bool
+		wantData string
+	}{
+		{"create", nil, false, "new"},
+		{"update owned", &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "app-sidecar", Namespace: "default", Annotations: map[string]string{AnnotationWorkload: "app"}},
+			Data:       map[string][]byte{ConfigKey: []byte("old")},
+		}, false, "new"},
+		{"refuse foreign", &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "app-sidecar", Namespace: "default"},
+			Data:       map[string][]byte{ConfigKey: []byte("user")},
+		}, true, "user"},
+		{"refuse other workload", &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "app-sidecar", Namespace: "default", Annotations: map[string]string{AnnotationWorkload: "other"}},
+			Data:       map[string][]byte{ConfigKey: []byte("other")},
+		}, true, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if tt.existing != nil {
+				clientset = fake.NewSimpleClientset(tt.existing)
+			}
+			client := kubernetes.NewFakeClientSets(clientset, nil, nil, "", nil)
+			newSecret := &corev1.Secret{
+				ObjectMeta: v1.ObjectMeta{Name: Name("app"), Namespace: "default", Annotations: map[string]string{AnnotationWorkload: "app"}},
+				Data:       map[string][]byte{ConfigKey: []byte("new")},
+			}
+			err := NewSecret(client, *controller.NewControllerOptions()).Apply(context.TODO(), newSecret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply error = %v, wantErr %v", err, tt.wantErr)
+			}
+			current, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), "app-sidecar", v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(current.Data[ConfigKey]) != tt.wantData {
+				t.Errorf("data = %q, want %q", current.Data[ConfigKey], tt.wantData)
+			}
+		})
+	}
+}
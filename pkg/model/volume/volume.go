@@ -0,0 +1,164 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/utils/tools"
+	"path"
+)
+
+type volume struct {
+	sidecar sidecar.Options
+}
+
+type Volume interface {
+	SharedLog(spec *corev1.PodSpec, container *corev1.Container, logPath, sizeLimit string, targets []string) error
+	Storage(spec *corev1.PodSpec, container *corev1.Container, storagePath, sizeLimit string) error
+}
+
+func NewVolume(sidecar sidecar.Options) Volume {
+	return &volume{
+		sidecar: sidecar,
+	}
+}
+
+// SharedLog 在应用容器与sidecar容器之间注入共享日志卷
+// 如果目标应用容器已经在logPath挂载了卷,则复用该卷,否则新增emptyDir卷;
+// pod中已存在同名但不是emptyDir的卷时返回错误,避免sidecar挂载应用的其他卷
+func (v *volume) SharedLog(spec *corev1.PodSpec, container *corev1.Container, logPath, sizeLimit string, targets []string) error {
+	logPath = path.Clean(logPath)
+	// 解析emptyDir大小限制
+	limit, err := parseSizeLimit(sizeLimit)
+	if err != nil {
+		return fmt.Errorf("共享日志卷大小限制 %s 格式错误: %w", sizeLimit, err)
+	}
+	// 获取需要共享日志目录的应用容器下标
+	var indexes []int
+	for i, c := range spec.Containers {
+		if len(targets) == 0 || tools.WhetherExists(c.Name, targets) {
+			indexes = append(indexes, i)
+		}
+	}
+	// 查找应用容器中是否已经在日志路径挂载了卷
+	name := ""
+	for _, i := range indexes {
+		for _, m := range spec.Containers[i].VolumeMounts {
+			if path.Clean(m.MountPath) == logPath {
+				name = m.Name
+				break
+			}
+		}
+		if name != "" {
+			break
+		}
+	}
+	if name == "" {
+		name = v.sidecar.LogVolumeName
+		for _, existing := range spec.Volumes {
+			if existing.Name == name && existing.EmptyDir == nil {
+				return fmt.Errorf("pod中已存在名称为%s的非emptyDir卷,无法作为共享日志卷", name)
+			}
+		}
+		addEmptyDir(spec, name, limit)
+	}
+	// 为未挂载日志目录的应用容器添加挂载
+	for _, i := range indexes {
+		if !hasMount(spec.Containers[i].VolumeMounts, logPath) {
+			spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      name,
+				MountPath: logPath,
+			})
+		}
+	}
+	// sidecar容器只读挂载日志目录
+	if !hasMount(container.VolumeMounts, logPath) {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: logPath,
+			ReadOnly:  true,
+		})
+	}
+	return nil
+}
+
+// Storage 为sidecar容器注入fluentBit位置数据库与文件缓冲卷,sidecar容器重启后采集位置与缓冲数据不丢失
+func (v *volume) Storage(spec *corev1.PodSpec, container *corev1.Container, storagePath, sizeLimit string) error {
+	storagePath = path.Clean(storagePath)
+	limit, err := parseSizeLimit(sizeLimit)
+	if err != nil {
+		return fmt.Errorf("fluentBit存储卷大小限制 %s 格式错误: %w", sizeLimit, err)
+	}
+	addEmptyDir(spec, v.sidecar.StorageVolumeName, limit)
+	if !hasMount(container.VolumeMounts, storagePath) {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      v.sidecar.StorageVolumeName,
+			MountPath: storagePath,
+		})
+	}
+	return nil
+}
+
+// parseSizeLimit 解析emptyDir大小限制,为空则不限制
+func parseSizeLimit(sizeLimit string) (*resource.Quantity, error) {
+	if sizeLimit == "" {
+		return nil, nil
+	}
+	q, err := resource.ParseQuantity(sizeLimit)
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// addEmptyDir 为pod添加emptyDir卷,同名卷已存在时跳过
+func addEmptyDir(spec *corev1.PodSpec, name string, limit *resource.Quantity) {
+	if hasVolume(spec.Volumes, name) {
+		return
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{
+				SizeLimit: limit,
+			},
+		},
+	})
+}
+
+// hasVolume 检查pod是否已经存在同名卷
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, v := range volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMount 检查容器是否已经在mountPath挂载了卷
+func hasMount(mounts []corev1.VolumeMount, mountPath string) bool {
+	for _, m := range mounts {
+		if path.Clean(m.MountPath) == mountPath {
+			return true
+		}
+	}
+	return false
+}
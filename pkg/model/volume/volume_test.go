@@ -0,0 +1,108 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"kube-sidecar/pkg/clientset/sidecar"
+	"reflect"
+	"testing"
+)
+
+func TestSharedLog(t *testing.T) {
+	emptyDir := corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+	hostPath := corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/data"}}
+	cases := []struct {
+		name       string
+		volumes    []corev1.Volume
+		mounts     []corev1.VolumeMount
+		targets    []string
+		sizeLimit  string
+		wantVolume string
+		// wantMounted 挂载了日志目录的应用容器
+		wantMounted []string
+		wantErr     bool
+	}{
+		{name: "new emptyDir", wantVolume: "sidecar-logs", wantMounted: []string{"app", "proxy"}},
+		{name: "size limit", sizeLimit: "1Gi", wantVolume: "sidecar-logs", wantMounted: []string{"app", "proxy"}},
+		{name: "invalid size limit", sizeLimit: "1GB", wantErr: true},
+		{name: "target containers", targets: []string{"app"}, wantVolume: "sidecar-logs", wantMounted: []string{"app"}},
+		{
+			name:        "reuse existing mount",
+			volumes:     []corev1.Volume{{Name: "logs", VolumeSource: hostPath}},
+			mounts:      []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/app/"}},
+			wantVolume:  "logs",
+			wantMounted: []string{"app", "proxy"},
+		},
+		{
+			name:        "existing emptyDir with the same name",
+			volumes:     []corev1.Volume{{Name: "sidecar-logs", VolumeSource: emptyDir}},
+			wantVolume:  "sidecar-logs",
+			wantMounted: []string{"app", "proxy"},
+		},
+		{
+			name:    "name clash with other volume",
+			volumes: []corev1.Volume{{Name: "sidecar-logs", VolumeSource: hostPath}},
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spec := &corev1.PodSpec{
+				Volumes: c.volumes,
+				Containers: []corev1.Container{
+					{Name: "app", VolumeMounts: c.mounts},
+					{Name: "proxy"},
+				},
+			}
+			s := &corev1.Container{Name: "sidecar"}
+			err := NewVolume(*sidecar.NewSidecarOptions()).SharedLog(spec, s, "/var/log/app", c.sizeLimit, c.targets)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, c.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(s.VolumeMounts) != 1 || s.VolumeMounts[0].Name != c.wantVolume || !s.VolumeMounts[0].ReadOnly {
+				t.Errorf("sidecar mounts = %v", s.VolumeMounts)
+			}
+			var mounted []string
+			for _, container := range spec.Containers {
+				for _, m := range container.VolumeMounts {
+					if m.Name == c.wantVolume {
+						mounted = append(mounted, container.Name)
+					}
+				}
+			}
+			if !reflect.DeepEqual(mounted, c.wantMounted) {
+				t.Errorf("mounted containers = %v, want %v", mounted, c.wantMounted)
+			}
+			var volumes []corev1.Volume
+			for _, v := range spec.Volumes {
+				if v.Name == c.wantVolume {
+					volumes = append(volumes, v)
+				}
+			}
+			if len(volumes) != 1 {
+				t.Fatalf("volumes named %s = %d, want 1", c.wantVolume, len(volumes))
+			}
+			if c.sizeLimit != "" && volumes[0].EmptyDir.SizeLimit.String() != c.sizeLimit {
+				t.Errorf("sizeLimit = %v, want %s", volumes[0].EmptyDir.SizeLimit, c.sizeLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// Entry 定义配置块中的一行配置
+type Entry struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// Section 定义fluentBit配置块,如SERVICE、INPUT、FILTER、OUTPUT
+type Section struct {
+	Name    string
+	Line    int
+	Entries []Entry
+}
+
+// Get 获取配置块中key对应的值,key大小写不敏感
+func (s Section) Get(key string) (string, bool) {
+	for _, e := range s.Entries {
+		if strings.EqualFold(e.Key, key) {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+// Plugin 获取配置块的插件名称
+func (s Section) Plugin() string {
+	name, _ := s.Get("Name")
+	return strings.ToLower(name)
+}
+
+// Parse 将fluentBit配置文件解析为配置块,同时返回格式问题
+func Parse(data []byte) ([]Section, []Problem) {
+	var (
+		sections []Section
+		problems []Problem
+		// 第一行配置的缩进,后续配置缩进需保持一致
+		indent string
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimLeft(text, " \t")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lead := text[:len(text)-len(trimmed)]
+		switch {
+		case strings.HasPrefix(trimmed, "["):
+			if lead != "" {
+				problems = append(problems, warningf(line, "", "配置块名称不应缩进"))
+			}
+			if !strings.HasSuffix(trimmed, "]") {
+				problems = append(problems, errorf(line, "", "配置块名称 %s 格式错误", trimmed))
+				continue
+			}
+			sections = append(sections, Section{
+				Name: strings.ToUpper(strings.TrimSpace(trimmed[1 : len(trimmed)-1])),
+				Line: line,
+			})
+		case strings.HasPrefix(trimmed, "@"):
+			// @INCLUDE、@SET等指令
+			continue
+		default:
+			if len(sections) == 0 {
+				problems = append(problems, errorf(line, "", "配置 %s 不属于任何配置块", trimmed))
+				continue
+			}
+			current := &sections[len(sections)-1]
+			switch {
+			case lead == "":
+				problems = append(problems, warningf(line, current.Name, "配置缺少缩进"))
+			case indent == "":
+				indent = lead
+			case lead != indent:
+				problems = append(problems, warningf(line, current.Name, "缩进与第一行配置不一致"))
+			}
+			key, value := trimmed, ""
+			if i := strings.IndexAny(trimmed, " \t"); i > 0 {
+				key, value = trimmed[:i], strings.TrimSpace(trimmed[i+1:])
+			}
+			current.Entries = append(current.Entries, Entry{Key: key, Value: value, Line: line})
+		}
+	}
+	return sections, problems
+}
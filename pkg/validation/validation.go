@@ -0,0 +1,281 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 问题级别
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem 定义校验发现的问题
+type Problem struct {
+	Line     int    `json:"line,omitempty" yaml:"line,omitempty"`
+	Section  string `json:"section,omitempty" yaml:"section,omitempty"`
+	Severity string `json:"severity,omitempty" yaml:"severity,omitempty"`
+	Message  string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+func (p Problem) String() string {
+	if p.Section == "" {
+		return fmt.Sprintf("%d: [%s] %s", p.Line, p.Severity, p.Message)
+	}
+	return fmt.Sprintf("%d: [%s] %s: %s", p.Line, p.Severity, p.Section, p.Message)
+}
+
+func errorf(line int, section, format string, args ...interface{}) Problem {
+	return Problem{Line: line, Section: section, Severity: SeverityError, Message: fmt.Sprintf(format, args...)}
+}
+
+func warningf(line int, section, format string, args ...interface{}) Problem {
+	return Problem{Line: line, Section: section, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)}
+}
+
+// Problems 校验问题列表
+type Problems []Problem
+
+// HasErrors 是否存在error级别问题
+func (p Problems) HasErrors() bool {
+	for _, problem := range p {
+		if problem.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err 将error级别问题合并为error,不存在时返回nil
+func (p Problems) Err() error {
+	var messages []string
+	for _, problem := range p {
+		if problem.Severity == SeverityError {
+			messages = append(messages, problem.String())
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return fmt.Errorf("fluentBit配置校验失败: %s", strings.Join(messages, "; "))
+}
+
+// plugin 定义插件支持的配置项
+type plugin struct {
+	// 必填配置项
+	required []string
+	// 支持的配置项
+	keys []string
+	// 支持的配置项前缀
+	prefixes []string
+}
+
+// 各类配置块通用配置项
+var (
+	commonKeys = map[string][]string{
+		"INPUT":  {"Name", "Tag", "Alias", "Mem_Buf_Limit", "storage.type", "storage.pause_on_chunks_overlimit", "Threaded", "Log_Level"},
+		"FILTER": {"Name", "Match", "Match_Regex", "Alias", "Log_Level"},
+		"OUTPUT": {"Name", "Match", "Match_Regex", "Alias", "Retry_Limit", "storage.total_limit_size", "Workers", "Log_Level"},
+	}
+	commonPrefixes = map[string][]string{
+		"OUTPUT": {"tls", "net."},
+	}
+)
+
+// serviceKeys SERVICE配置块支持的配置项
+var serviceKeys = []string{
+	"Flush", "Grace", "Daemon", "Log_File", "Log_Level", "Parsers_File", "Plugins_File", "Streams_File",
+	"HTTP_Server", "HTTP_Listen", "HTTP_Port", "Health_Check", "HC_Errors_Count", "HC_Retry_Failure_Count", "HC_Period",
+	"Coro_Stack_Size", "storage.path", "storage.sync", "storage.checksum", "storage.backlog.mem_limit",
+	"storage.max_chunks_up", "storage.metrics", "storage.delete_irrecoverable_chunks",
+}
+
+// plugins 各类配置块支持的插件,key为配置块名称与插件名称
+var plugins = map[string]map[string]plugin{
+	"INPUT": {
+		"tail": {
+			required: []string{"Path"},
+			keys: []string{"Path", "Exclude_Path", "Path_Key", "Parser", "DB", "DB.sync", "DB.locking", "DB.journal_mode",
+				"Skip_Long_Lines", "Skip_Empty_Lines", "Refresh_Interval", "Read_from_Head", "Rotate_Wait", "Ignore_Older",
+				"Buffer_Chunk_Size", "Buffer_Max_Size", "Key", "Offset_Key", "Docker_Mode", "Docker_Mode_Flush",
+				"Docker_Mode_Parser", "multiline.parser", "Multiline", "Multiline_Flush", "Parser_Firstline", "Inotify_Watcher"},
+		},
+	},
+	"FILTER": {
+		"grep": {
+			keys: []string{"Regex", "Exclude", "Logical_Op"},
+		},
+		"modify": {
+			keys: []string{"Set", "Add", "Remove", "Remove_wildcard", "Remove_regex", "Rename", "Hard_rename", "Copy", "Hard_copy", "Condition"},
+		},
+		"record_modifier": {
+			keys: []string{"Record", "Remove_key", "Allowlist_key", "Whitelist_key", "Uuid_key"},
+		},
+		"nest": {
+			required: []string{"Operation"},
+			keys:     []string{"Operation", "Wildcard", "Nest_under", "Nested_under", "Add_prefix", "Remove_prefix"},
+		},
+		"lua": {
+			required: []string{"call"},
+			keys:     []string{"script", "call", "code", "type_int_key", "type_array_key", "protected_mode", "time_as_table"},
+		},
+	},
+	"OUTPUT": {
+		"es": {
+			required: []string{"Host"},
+			keys: []string{"Host", "Port", "Path", "Index", "Type", "HTTP_User", "HTTP_Passwd", "Cloud_ID", "Cloud_Auth",
+				"Logstash_Format", "Logstash_Prefix", "Logstash_DateFormat", "Time_Key", "Time_Key_Format", "Include_Tag_Key",
+				"Tag_Key", "Generate_ID", "Id_Key", "Replace_Dots", "Trace_Output", "Trace_Error", "Buffer_Size",
+				"Pipeline", "Suppress_Type_Name", "Write_Operation", "Compress", "AWS_Auth", "AWS_Region"},
+		},
+		"kafka": {
+			required: []string{"Brokers", "Topics"},
+			keys: []string{"Brokers", "Topics", "Topic_Key", "Dynamic_Topic", "Format", "Message_Key", "Message_Key_Field",
+				"Timestamp_Key", "Timestamp_Format", "Queue_Full_Retries"},
+			prefixes: []string{"rdkafka."},
+		},
+		"stdout": {
+			keys: []string{"Format", "json_date_key", "json_date_format"},
+		},
+	},
+}
+
+// Validate 校验渲染后的fluentBit配置文件
+func Validate(data []byte) Problems {
+	sections, problems := Parse(data)
+	result := Problems(problems)
+	inputs, outputs := 0, 0
+	for _, section := range sections {
+		switch section.Name {
+		case "SERVICE":
+			result = append(result, checkKeys(section, serviceKeys, nil)...)
+		case "INPUT", "FILTER", "OUTPUT":
+			if section.Name == "INPUT" {
+				inputs++
+			}
+			if section.Name == "OUTPUT" {
+				outputs++
+			}
+			result = append(result, checkPlugin(section)...)
+		default:
+			result = append(result, errorf(section.Line, section.Name, "未知的配置块"))
+		}
+		// 检查没有值的配置项
+		result = append(result, checkEmpty(section)...)
+	}
+	if inputs == 0 {
+		result = append(result, errorf(0, "", "缺少INPUT配置块"))
+	}
+	if outputs == 0 {
+		result = append(result, errorf(0, "", "缺少OUTPUT配置块"))
+	}
+	return result
+}
+
+// checkPlugin 校验INPUT、FILTER、OUTPUT配置块
+func checkPlugin(section Section) []Problem {
+	var problems []Problem
+	name := section.Plugin()
+	if name == "" {
+		return append(problems, errorf(section.Line, section.Name, "缺少Name配置"))
+	}
+	if section.Name != "INPUT" {
+		_, match := section.Get("Match")
+		_, matchRegex := section.Get("Match_Regex")
+		if !match && !matchRegex {
+			problems = append(problems, errorf(section.Line, section.Name, "%s缺少Match配置", name))
+		}
+	}
+	p, ok := plugins[section.Name][name]
+	if !ok {
+		return append(problems, warningf(section.Line, section.Name, "未知的插件 %s,跳过配置项校验", name))
+	}
+	for _, key := range p.required {
+		value, ok := section.Get(key)
+		if !ok || value == "" {
+			problems = append(problems, errorf(section.Line, section.Name, "%s缺少必填配置 %s", name, key))
+		}
+	}
+	if name == "kafka" {
+		problems = append(problems, checkBrokers(section)...)
+	}
+	if name == "lua" {
+		_, script := section.Get("script")
+		_, code := section.Get("code")
+		if !script && !code {
+			problems = append(problems, errorf(section.Line, section.Name, "lua缺少script或code配置"))
+		}
+	}
+	keys := append(append([]string{}, commonKeys[section.Name]...), p.keys...)
+	prefixes := append(append([]string{}, commonPrefixes[section.Name]...), p.prefixes...)
+	return append(problems, checkKeys(section, keys, prefixes)...)
+}
+
+// checkKeys 检查未知的配置项
+func checkKeys(section Section, keys, prefixes []string) []Problem {
+	var problems []Problem
+	for _, e := range section.Entries {
+		if !knownKey(e.Key, keys, prefixes) {
+			problems = append(problems, warningf(e.Line, section.Name, "未知的配置项 %s", e.Key))
+		}
+	}
+	return problems
+}
+
+// checkEmpty 检查没有值的配置项
+func checkEmpty(section Section) []Problem {
+	var problems []Problem
+	for _, e := range section.Entries {
+		if e.Value == "" {
+			problems = append(problems, warningf(e.Line, section.Name, "配置项 %s 没有值", e.Key))
+		}
+	}
+	return problems
+}
+
+// checkBrokers 检查kafka brokers地址格式
+func checkBrokers(section Section) []Problem {
+	var problems []Problem
+	brokers, _ := section.Get("Brokers")
+	for _, broker := range strings.Split(brokers, ",") {
+		broker = strings.TrimSpace(broker)
+		if broker == "" {
+			continue
+		}
+		if strings.HasPrefix(broker, ":") || strings.HasSuffix(broker, ":") {
+			problems = append(problems, errorf(section.Line, section.Name, "kafka broker地址 %s 缺少主机或端口", broker))
+		}
+	}
+	return problems
+}
+
+func knownKey(key string, keys, prefixes []string) bool {
+	for _, k := range keys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(strings.ToLower(key), strings.ToLower(p)) {
+			return true
+		}
+	}
+	return false
+}
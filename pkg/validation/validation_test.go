@@ -0,0 +1,123 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		// 期望出现的问题,格式为"级别 关键字"
+		want []string
+	}{
+		{
+			name: "valid",
+			config: `
+[SERVICE]
+    Log_Level info
+[INPUT]
+    Name tail
+    Path /tmp/*.log
+[FILTER]
+    Name grep
+    Match app.logging
+    Exclude log /healthz
+[OUTPUT]
+    Name kafka
+    Match app.logging
+    Brokers kafka:9092
+    Topics app
+    rdkafka.security.protocol SASL_PLAINTEXT
+`,
+		},
+		{
+			name: "missing required keys",
+			config: `
+[INPUT]
+    Name tail
+[OUTPUT]
+    Name es
+    Match *
+`,
+			want: []string{"error Path", "error Host"},
+		},
+		{
+			name: "kafka broker without host",
+			config: `
+[INPUT]
+    Name tail
+    Path /tmp/*.log
+[OUTPUT]
+    Name kafka
+    Match *
+    Brokers :9092
+    Topics app
+`,
+			want: []string{"error :9092"},
+		},
+		{
+			name: "unknown key and section",
+			config: `
+[INPUT]
+    Name tail
+    Path /tmp/*.log
+[OUTPUT]
+    Name es
+    Match *
+    Host es
+    User root
+[PARSERS]
+    Name docker
+`,
+			want: []string{"warning User", "error 未知的配置块"},
+		},
+		{
+			name:   "inconsistent indentation",
+			config: "[INPUT]\n    Name tail\n\tPath /tmp/*.log\n[OUTPUT]\n    Name stdout\n    Match *\n",
+			want:   []string{"warning 缩进"},
+		},
+		{
+			name:   "missing output",
+			config: "[INPUT]\n    Name tail\n    Path /tmp/*.log\n",
+			want:   []string{"error OUTPUT"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			problems := Validate([]byte(c.config))
+			if len(c.want) == 0 && len(problems) > 0 {
+				t.Fatalf("unexpected problems: %v", problems)
+			}
+			for _, w := range c.want {
+				parts := strings.SplitN(w, " ", 2)
+				found := false
+				for _, p := range problems {
+					if p.Severity == parts[0] && strings.Contains(p.Message, parts[1]) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected %q in %v", w, problems)
+				}
+			}
+		})
+	}
+}
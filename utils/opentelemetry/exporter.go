@@ -0,0 +1,106 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package opentelemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+	"kube-sidecar/pkg/clientset/tracing"
+	"net/url"
+	"os"
+)
+
+// exporter 根据tracing.exporter创建span导出器,none时返回nil
+func (o *openTelemetry) exporter(ctx context.Context) (tracesdk.SpanExporter, error) {
+	switch o.Tracing.Exporter {
+	case tracing.ExporterOTLPGRPC:
+		options := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(o.Tracing.Endpoint),
+			otlptracegrpc.WithHeaders(o.Tracing.Headers),
+		}
+		if o.Tracing.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		} else {
+			config, err := tlsConfig(o.Tracing)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(config)))
+		}
+		return otlptracegrpc.New(ctx, options...)
+	case tracing.ExporterOTLPHTTP:
+		options := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(o.Tracing.Endpoint),
+			otlptracehttp.WithHeaders(o.Tracing.Headers),
+		}
+		if o.Tracing.URLPath != "" {
+			options = append(options, otlptracehttp.WithURLPath(o.Tracing.URLPath))
+		}
+		if o.Tracing.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		} else {
+			config, err := tlsConfig(o.Tracing)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, otlptracehttp.WithTLSClientConfig(config))
+		}
+		return otlptracehttp.New(ctx, options...)
+	case tracing.ExporterJaeger:
+		endpoint := url.URL{
+			Scheme: o.Jeager.Scheme,
+			Host:   fmt.Sprintf("%s:%s", o.Jeager.Host, o.Jeager.Port),
+			Path:   o.Jeager.Path,
+		}
+		// Create the Jaeger exporter
+		return jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(endpoint.String())))
+	case tracing.ExporterNone, "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("不支持的链路跟踪导出方式 %s", o.Tracing.Exporter)
+	}
+}
+
+// tlsConfig 根据tracing配置创建TLS配置
+func tlsConfig(options tracing.Options) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+	if options.CAFile != "" {
+		ca, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("CA证书%s格式错误", options.CAFile)
+		}
+	}
+	if options.CertFile != "" || options.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2023 QKP Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package opentelemetry
+
+import (
+	"context"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"kube-sidecar/pkg/clientset/tracing"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// traceReceiver 进程内OTLP gRPC接收端,记录收到的请求与header
+type traceReceiver struct {
+	collectortrace.UnimplementedTraceServiceServer
+	requests chan *collectortrace.ExportTraceServiceRequest
+	headers  chan metadata.MD
+}
+
+func (r *traceReceiver) Export(ctx context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.headers <- md
+	r.requests <- req
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+// exportSpan 按配置创建TracerProvider并导出一个span
+func exportSpan(t *testing.T, options tracing.Options) {
+	t.Helper()
+	o := &openTelemetry{Tracing: options}
+	tp, err := o.TracerProvider("kube-sidecar", "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tp.Tracer("test").Start(context.Background(), "reconcile")
+	span.End()
+	if err = tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// spanNames 获取导出请求中的span名称与资源属性
+func spanNames(req *collectortrace.ExportTraceServiceRequest) (names []string, attributes map[string]string) {
+	attributes = make(map[string]string)
+	for _, rs := range req.ResourceSpans {
+		for _, kv := range rs.Resource.Attributes {
+			attributes[kv.Key] = kv.Value.GetStringValue()
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				names = append(names, s.Name)
+			}
+		}
+	}
+	return names, attributes
+}
+
+func TestOTLPGRPCExporter(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := &traceReceiver{
+		requests: make(chan *collectortrace.ExportTraceServiceRequest, 1),
+		headers:  make(chan metadata.MD, 1),
+	}
+	server := grpc.NewServer()
+	collectortrace.RegisterTraceServiceServer(server, receiver)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	options := *tracing.NewTracingOptions()
+	options.Exporter = tracing.ExporterOTLPGRPC
+	options.Endpoint = listener.Addr().String()
+	options.Headers = map[string]string{"authorization": "Bearer token"}
+	options.ResourceAttributes = []tracing.Attribute{{Key: "k8s.cluster.name", Value: "kind"}}
+	exportSpan(t, options)
+
+	names, attributes := spanNames(<-receiver.requests)
+	if len(names) != 1 || names[0] != "reconcile" {
+		t.Errorf("spans = %v", names)
+	}
+	if attributes["k8s.cluster.name"] != "kind" || attributes["service.name"] != "kube-sidecar" {
+		t.Errorf("resource attributes = %v", attributes)
+	}
+	if md := <-receiver.headers; strings.Join(md.Get("authorization"), "") != "Bearer token" {
+		t.Errorf("headers = %v", md)
+	}
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	requests := make(chan *collectortrace.ExportTraceServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("X-Scope-OrgID") != "team" {
+			t.Errorf("unexpected request %s headers %v", r.URL.Path, r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Error(err)
+		}
+		requests <- req
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer server.Close()
+
+	options := *tracing.NewTracingOptions()
+	options.Exporter = tracing.ExporterOTLPHTTP
+	options.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	options.Headers = map[string]string{"X-Scope-OrgID": "team"}
+	exportSpan(t, options)
+
+	if names, _ := spanNames(<-requests); len(names) != 1 || names[0] != "reconcile" {
+		t.Errorf("spans = %v", names)
+	}
+}
+
+func TestSamplingRatioZero(t *testing.T) {
+	options := *tracing.NewTracingOptions()
+	options.Exporter = tracing.ExporterNone
+	options.SamplingRatio = 0
+	tp, err := (&openTelemetry{Tracing: options}).TracerProvider("kube-sidecar", "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tp.Tracer("test").Start(context.Background(), "reconcile")
+	if span.SpanContext().IsSampled() {
+		t.Error("span sampled with ratio 0")
+	}
+}
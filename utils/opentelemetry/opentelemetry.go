@@ -20,82 +20,93 @@ package opentelemetry
 
 import (
 	"context"
-	"fmt"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"kube-sidecar/pkg/clientset/controller"
 	"kube-sidecar/pkg/clientset/fluent"
 	jg "kube-sidecar/pkg/clientset/jaeger"
 	"kube-sidecar/pkg/clientset/kubernetes"
 	lg "kube-sidecar/pkg/clientset/logging"
+	"kube-sidecar/pkg/clientset/monitoring"
 	"kube-sidecar/pkg/clientset/sidecar"
+	"kube-sidecar/pkg/clientset/tracing"
 	"kube-sidecar/pkg/clientset/workload"
 	"log"
-	"net/url"
 	"time"
 
 	"kube-sidecar/pkg/controller/deploy"
 )
 
 type openTelemetry struct {
-	K8sClient kubernetes.Client
-	FluentBit fluent.Options
-	Sidecar   sidecar.Options
-	Jeager    jg.Options
-	WhiteList workload.Options
+	K8sClient  kubernetes.Client
+	FluentBit  fluent.Options
+	Sidecar    sidecar.Options
+	Jeager     jg.Options
+	Tracing    tracing.Options
+	WhiteList  workload.Options
+	Controller controller.Options
+	Monitoring monitoring.Options
 }
 
 type OpenTelemetry interface {
 	TracerProvider(service, environment string, id int64) (*tracesdk.TracerProvider, error)
+	RegisterGlobalTracerProvider(service, environment string, id int64)
 }
 
-func NewOpenTelemetry(k8sClient kubernetes.Client, fluentBit fluent.Options, sidecar sidecar.Options, jeager jg.Options, whiteList workload.Options) OpenTelemetry {
+func NewOpenTelemetry(k8sClient kubernetes.Client, fluentBit fluent.Options, sidecar sidecar.Options, jeager jg.Options, tracing tracing.Options, whiteList workload.Options, controller controller.Options, monitoring monitoring.Options) OpenTelemetry {
 	return &openTelemetry{
-		K8sClient: k8sClient,
-		FluentBit: fluentBit,
-		Sidecar:   sidecar,
-		Jeager:    jeager,
-		WhiteList: whiteList,
+		K8sClient:  k8sClient,
+		FluentBit:  fluentBit,
+		Sidecar:    sidecar,
+		Jeager:     jeager,
+		Tracing:    tracing,
+		WhiteList:  whiteList,
+		Controller: controller,
+		Monitoring: monitoring,
 	}
 }
 
-// TracerProvider 设置tracerProvider方法
+// TracerProvider 设置tracerProvider方法,按tracing.exporter选择导出方式,none时只生成span不导出
 func (o *openTelemetry) TracerProvider(service, environment string, id int64) (*tracesdk.TracerProvider, error) {
-	endpoint, _ := url.Parse(fmt.Sprintf("%s://%s", o.Jeager.Scheme, o.Jeager.Host))
-	// 添加端口号
-	endpoint.Host = fmt.Sprintf("%s:%s", o.Jeager.Host, o.Jeager.Port)
-	// 拼接路径
-	endpoint.Path += o.Jeager.Path
-	// Create the Jaeger exporter
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(endpoint.String())))
+	exp, err := o.exporter(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	tp := tracesdk.NewTracerProvider(
-		// Always be sure to batch in production.
-		tracesdk.WithBatcher(exp),
+	attributes := []attribute.KeyValue{
+		semconv.ServiceName(service),
+		attribute.String("environment", environment),
+		attribute.Int64("ID", id),
+	}
+	for _, a := range o.Tracing.ResourceAttributes {
+		attributes = append(attributes, attribute.String(a.Key, a.Value))
+	}
+	options := []tracesdk.TracerProviderOption{
+		// 父span已采样时跟随父span,否则按比例采样
+		tracesdk.WithSampler(tracesdk.ParentBased(tracesdk.TraceIDRatioBased(o.Tracing.SamplingRatio))),
 		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceName(service),
-			attribute.String("environment", environment),
-			attribute.Int64("ID", id),
-		)),
-	)
-	return tp, nil
+		tracesdk.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attributes...)),
+	}
+	if exp != nil {
+		// Always be sure to batch in production.
+		options = append(options, tracesdk.WithBatcher(exp))
+	}
+	return tracesdk.NewTracerProvider(options...), nil
 }
 
 // RegisterGlobalTracerProvider 注册全局的tracerProvider
-func (o *openTelemetry) RegisterGlobalTracerProvider(tracerName, spanName, service, environment string, id int64) {
+func (o *openTelemetry) RegisterGlobalTracerProvider(service, environment string, id int64) {
 	tp, err := o.TracerProvider(service, environment, id)
 	if err != nil {
-		lg.Logger.Error("初始化TracerProvider失败,错误信息" + err.Error())
+		lg.Logger.Error("初始化TracerProvider失败,不导出链路数据,错误信息" + err.Error())
+		tp = tracesdk.NewTracerProvider()
 	}
-	// 注册全局TracerProvider
+	// 注册全局TracerProvider,通过traceparent header向kubernetes API传递trace上下文
 	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -109,9 +120,6 @@ func (o *openTelemetry) RegisterGlobalTracerProvider(tracerName, spanName, servi
 		}
 	}(ctx)
 
-	tr := tp.Tracer(tracerName)
-	_, span := tr.Start(ctx, spanName)
-	defer span.End()
-	// Context 向下传递
-	deploy.NewDeployment(o.K8sClient, o.FluentBit, o.Sidecar, o.Jeager, o.WhiteList).Watch(ctx, tracerName, spanName)
+	// 每次处理deployment生成一条trace,Context 向下传递
+	deploy.NewDeployment(o.K8sClient, o.FluentBit, o.Sidecar, o.Jeager, o.WhiteList, o.Controller, o.Monitoring).Watch(ctx)
 }
@@ -20,6 +20,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	lg "kube-sidecar/pkg/clientset/logging"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -42,6 +43,17 @@ func SetDefaultValueNotExist(key string, defaultValue string) string {
 	}
 }
 
+// SplitNotEmpty 按分隔符拆分字符串并去除空白元素
+func SplitNotEmpty(s, sep string) []string {
+	var result []string
+	for _, v := range strings.Split(s, sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
 // WorkloadContainerNames 获取工作负载Deployment\StatefulSet\DaemonSet的所有containers名称
 func WorkloadContainerNames(objType string, object interface{}) []string {
 	var (